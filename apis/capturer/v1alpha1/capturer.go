@@ -0,0 +1,64 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultAPIVersions maps the kinds which were supported before ResourceAPIVersion
+// was introduced to their apiVersion, so that existing Capturers keep working.
+var DefaultAPIVersions = map[string]string{
+	"ClusterRole":        "rbac.authorization.k8s.io/v1",
+	"ClusterRoleBinding": "rbac.authorization.k8s.io/v1",
+	"ConfigMap":          "v1",
+	"Deployment":         "apps/v1",
+	"Secret":             "v1",
+	"Service":            "v1",
+	"ServiceAccount":     "v1",
+}
+
+// ResourceGroupVersionKind returns the GroupVersionKind of the resource to be captured
+func (c *Capturer) ResourceGroupVersionKind() (schema.GroupVersionKind, error) {
+	kind := c.Spec.ResourceKind
+	apiVersion := c.Spec.ResourceAPIVersion
+	if apiVersion == "" {
+		v, ok := DefaultAPIVersions[kind]
+		if !ok {
+			return schema.GroupVersionKind{}, fmt.Errorf("resourceApiVersion is required for resourceKind %s", kind)
+		}
+		apiVersion = v
+	}
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+
+	return gv.WithKind(kind), nil
+}
+
+// RevealsSecrets reports whether the Capturer captures the values of Secrets as they are
+func (c *Capturer) RevealsSecrets() bool {
+	gvk, err := c.ResourceGroupVersionKind()
+	if err != nil {
+		return false
+	}
+	return gvk.Group == "" && gvk.Kind == "Secret" && c.Spec.SecretPolicy == SecretPolicyPlaintext
+}
@@ -0,0 +1,48 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var _ = Describe("Capturer", func() {
+	DescribeTable("resolves the GroupVersionKind of the resource",
+		func(kind, apiVersion string, expected schema.GroupVersionKind) {
+			c := &Capturer{Spec: CapturerSpec{ResourceKind: kind, ResourceAPIVersion: apiVersion}}
+			Expect(c.ResourceGroupVersionKind()).To(Equal(expected))
+		},
+		Entry("a core kind by default", "ConfigMap", "", schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}),
+		Entry("a grouped kind by default", "Deployment", "", schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}),
+		Entry("a RBAC kind by default", "ClusterRole", "", schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}),
+		Entry("another version of a kind with the default", "Deployment", "apps/v1beta2", schema.GroupVersionKind{Group: "apps", Version: "v1beta2", Kind: "Deployment"}),
+		Entry("a custom resource", "Widget", "example.com/v1beta1", schema.GroupVersionKind{Group: "example.com", Version: "v1beta1", Kind: "Widget"}),
+	)
+
+	DescribeTable("rejects the kind without the apiVersion",
+		func(kind, apiVersion string) {
+			c := &Capturer{Spec: CapturerSpec{ResourceKind: kind, ResourceAPIVersion: apiVersion}}
+			_, err := c.ResourceGroupVersionKind()
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("a kind without the default", "Widget", ""),
+		Entry("an invalid apiVersion", "Widget", "example.com/v1/beta1"),
+	)
+})
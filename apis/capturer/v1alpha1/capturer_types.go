@@ -31,6 +31,13 @@ type CapturerSpec struct {
 
 	NamespacedResource bool `json:"namespacedResource"`
 
+	// ResourceAPIVersion is the apiVersion of the captured resource, e.g. `apps/v1`.
+	// It can be omitted for the core kinds listed in DefaultAPIVersions.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	ResourceAPIVersion string `json:"resourceApiVersion,omitempty"`
+
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:Format:=string
 
@@ -41,24 +48,250 @@ type CapturerSpec struct {
 
 	ResourceNamespace string `json:"resourceNamespace,omitempty"`
 
-	// +kubebuilder:validation:Required
+	// ResourceName is the exact name of the resource.
+	// At least one of ResourceName, ResourceNamePatterns, ResourceNameRegex or Selector must be specified.
+	// +kubebuilder:validation:Optional
 	// +kubebuilder:validation:Format:=string
 
-	ResourceName string `json:"resourceName"`
+	ResourceName string `json:"resourceName,omitempty"`
+
+	// ResourceNamePatterns is a list of glob patterns of the resource name, e.g. `aws-node*`.
+	// The glob semantics are the same as KeyFilter.
+	// +kubebuilder:validation:Optional
+
+	ResourceNamePatterns []string `json:"resourceNamePatterns,omitempty"`
+
+	// ResourceNameRegex is a regular expression of the resource name.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	ResourceNameRegex string `json:"resourceNameRegex,omitempty"`
+
+	// Exclude is a list of glob patterns of the resource name never to be captured.
+	// The glob semantics are the same as KeyFilter.
+	// +kubebuilder:validation:Optional
+
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Selector selects the resources by their labels.
+	// +kubebuilder:validation:Optional
+
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// NamespaceSelector selects the namespaces of the resources by their labels.
+	// It is only used for namespaced resources.
+	// +kubebuilder:validation:Optional
+
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Metadata defines the metadata kept in the captured manifest.
+	// By default, only name, namespace and labels are kept.
+	// +kubebuilder:validation:Optional
+
+	Metadata *MetadataPolicy `json:"metadata,omitempty"`
+
+	// SecretPolicy defines how the values of captured Secrets are published.
+	// `redact` replaces them with a placeholder, `hash` replaces them with their SHA-256 hash
+	// so that changes are still detected, and `plaintext` publishes them as they are.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=redact;hash;plaintext
+	// +kubebuilder:default=redact
+
+	SecretPolicy SecretPolicy `json:"secretPolicy,omitempty"`
+
+	// IgnoreFields is a list of paths of the fields removed from the captured manifest,
+	// either JSON pointers (`/metadata/annotations/foo`) or JSONPath
+	// (`.spec.template.metadata.annotations['kubectl.kubernetes.io/restartedAt']`).
+	// `*` matches any key or index.
+	// +kubebuilder:validation:Optional
+
+	IgnoreFields []string `json:"ignoreFields,omitempty"`
+
+	// Patch is a JSON patch (RFC 6902) in JSON or YAML applied to the captured manifest.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	Patch string `json:"patch,omitempty"`
 
 	// +kubebuilder:validation:Required
 
 	Outputs []string `json:"outputs"`
+
+	// Schedule takes a full snapshot of all the matched resources periodically in the cron syntax, e.g. `0 0 * * *`.
+	// The manifests which have not changed are not published.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	Schedule string `json:"schedule,omitempty"`
+
+	// ValidateRestorable runs a server-side dry-run apply of every captured manifest,
+	// so that the snapshots which would be rejected on restore are flagged.
+	// +kubebuilder:validation:Optional
+
+	ValidateRestorable bool `json:"validateRestorable,omitempty"`
+
+	// History keeps the captured manifests as ManifestSnapshots in the namespace of the Capturer,
+	// so that they are available even when the Outputs are unreachable.
+	// +kubebuilder:validation:Optional
+
+	History *HistoryPolicy `json:"history,omitempty"`
+}
+
+// HistoryPolicy defines the retention of the ManifestSnapshots per captured object.
+// The latest snapshot of each object is always kept.
+type HistoryPolicy struct {
+	// MaxCount is the maximum number of the snapshots kept per object. It is unlimited if omitted.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+
+	MaxCount int32 `json:"maxCount,omitempty"`
+
+	// MaxAge is the maximum age of the snapshots kept, e.g. `720h`. It is unlimited if omitted.
+	// +kubebuilder:validation:Optional
+
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// Condition types of Capturer
+const (
+	// CapturerReady means the last capture was published to all the Outputs
+	CapturerReady = "Ready"
+
+	// CapturerOutputsResolved means all the Outputs referred by the Capturer exist
+	CapturerOutputsResolved = "OutputsResolved"
+
+	// CapturerLastPublishSucceeded means the last capture was published without error
+	CapturerLastPublishSucceeded = "LastPublishSucceeded"
+
+	// CapturerRestorable means the manifest of the last capture passed the dry-run apply
+	CapturerRestorable = "Restorable"
+)
+
+// MaxCapturing is the maximum number of the objects listed in the status of Capturer
+const MaxCapturing = 20
+
+// SecretPolicy defines how the values of captured Secrets are published
+type SecretPolicy string
+
+const (
+	// SecretPolicyRedact replaces the values with a placeholder
+	SecretPolicyRedact SecretPolicy = "redact"
+
+	// SecretPolicyHash replaces the values with their SHA-256 hash
+	SecretPolicyHash SecretPolicy = "hash"
+
+	// SecretPolicyPlaintext keeps the values as they are
+	SecretPolicyPlaintext SecretPolicy = "plaintext"
+)
+
+// MetadataPolicy defines the metadata kept in the captured manifest.
+// managedFields and the other fields maintained by the cluster are never kept.
+type MetadataPolicy struct {
+	// Labels selects the labels to be kept. All the labels are kept if omitted.
+	// +kubebuilder:validation:Optional
+
+	Labels *KeyFilter `json:"labels,omitempty"`
+
+	// Annotations selects the annotations to be kept. No annotations are kept if omitted.
+	// `kubectl.kubernetes.io/last-applied-configuration` is removed unless it is included explicitly.
+	// +kubebuilder:validation:Optional
+
+	Annotations *KeyFilter `json:"annotations,omitempty"`
+
+	// +kubebuilder:validation:Optional
+
+	KeepOwnerReferences bool `json:"keepOwnerReferences,omitempty"`
+
+	// +kubebuilder:validation:Optional
+
+	KeepFinalizers bool `json:"keepFinalizers,omitempty"`
+}
+
+// KeyFilter selects keys by glob patterns, in which `*` matches any characters including `/`,
+// `?` matches any single character, `[...]` matches a character of the class
+// (negated by a leading `!` or `^`) and `\` escapes the following character
+type KeyFilter struct {
+	// Include is a list of the patterns of the keys to be kept. All the keys are kept if empty.
+	// +kubebuilder:validation:Optional
+
+	Include []string `json:"include,omitempty"`
+
+	// Exclude is a list of the patterns of the keys to be removed.
+	// +kubebuilder:validation:Optional
+
+	Exclude []string `json:"exclude,omitempty"`
 }
 
 // CapturerStatus defines the observed state of Capturer
 type CapturerStatus struct {
+	// ObservedGeneration is the generation of the Capturer spec which has been validated and captured.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// A list of pointers to currently running capturing object.
+	// Only the most recently captured ones are listed, up to MaxCapturing.
 	// +optional
 	Capturing []corev1.ObjectReference `json:"capturing,omitempty"`
+
+	// CapturingCount is the number of the objects captured and not deleted.
+	// +optional
+	CapturingCount int32 `json:"capturingCount,omitempty"`
+
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// LastCapturedAt is the time of the last capture.
+	// +optional
+	LastCapturedAt *metav1.Time `json:"lastCapturedAt,omitempty"`
+
+	// LastScheduleTime is the time of the last scheduled snapshot.
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// LastCaptured is the reference to the object of the last capture,
+	// including its resourceVersion and UID.
+	// +optional
+	LastCaptured *corev1.ObjectReference `json:"lastCaptured,omitempty"`
+
+	// LastManifestHash is the SHA-256 hash of the manifest last published to all the Outputs.
+	// It is left as it is when publishing the capture fails.
+	// +optional
+	LastManifestHash string `json:"lastManifestHash,omitempty"`
+
+	// Outputs is the result of the last capture per Output.
+	// +optional
+	Outputs []OutputResult `json:"outputs,omitempty"`
+}
+
+// OutputResult is the result of publishing a capture to an Output
+type OutputResult struct {
+	// Name of the Output
+	Name string `json:"name"`
+
+	// Succeeded reports whether the last capture was published
+	Succeeded bool `json:"succeeded"`
+
+	// Message describes the failure of the last publication.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastPublishedAt is the time of the last successful publication.
+	// +optional
+	LastPublishedAt *metav1.Time `json:"lastPublishedAt,omitempty"`
+
+	// URL is where the last capture was published for review, e.g. the pull request.
+	// +optional
+	URL string `json:"url,omitempty"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Kind",type=string,JSONPath=`.spec.resourceKind`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Last Captured",type=date,JSONPath=`.status.lastCapturedAt`
+// +kubebuilder:printcolumn:name="Object",type=string,JSONPath=`.status.lastCaptured.name`,priority=1
+// +kubebuilder:printcolumn:name="Hash",type=string,JSONPath=`.status.lastManifestHash`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Capturer is the Schema for the capturers API
 type Capturer struct {
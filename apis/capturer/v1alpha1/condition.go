@@ -0,0 +1,81 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Condition describes the state of a resource at a certain point
+type Condition struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Format:=string
+
+	Type string `json:"type"`
+
+	// +kubebuilder:validation:Required
+
+	Status corev1.ConditionStatus `json:"status"`
+
+	// +kubebuilder:validation:Optional
+
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	Reason string `json:"reason,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	Message string `json:"message,omitempty"`
+}
+
+// SetCondition adds the condition, or updates the one of the same type.
+// LastTransitionTime is only changed when the status changes.
+func SetCondition(conditions *[]Condition, condition Condition) {
+	if condition.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = metav1.Now()
+	}
+
+	for i := range *conditions {
+		c := &(*conditions)[i]
+		if c.Type != condition.Type {
+			continue
+		}
+
+		if c.Status == condition.Status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		*c = condition
+		return
+	}
+
+	*conditions = append(*conditions, condition)
+}
+
+// FindCondition returns the condition of the type, or nil if not found
+func FindCondition(conditions []Condition, conditionType string) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"sigs.k8s.io/yaml"
+)
+
+// Encryption defines the recipients of the captured Secrets.
+// The values in data and stringData are encrypted in the format of SOPS,
+// i.e. the keys are kept in clear and the values are encrypted with a data key
+// which is encrypted for each of the recipients, so that `sops -d` decrypts them.
+// Only the committed manifests are encrypted: the diffs and the summaries published along with them
+// compare the values of Secrets hashed, and the pull requests leave out the diffs.
+type Encryption struct {
+	// Age is a list of age recipients, e.g. `age1...`
+	// +kubebuilder:validation:Optional
+
+	Age []string `json:"age,omitempty"`
+
+	// PGP is a list of ASCII armored PGP public keys
+	// +kubebuilder:validation:Optional
+
+	PGP []string `json:"pgp,omitempty"`
+}
+
+const (
+	sopsVersion        = "3.7.1"
+	sopsEncryptedRegex = "^(data|stringData)$"
+)
+
+// Validate checks that all of the recipients can be parsed
+func (e *Encryption) Validate() error {
+	if len(e.Age) == 0 && len(e.PGP) == 0 {
+		return fmt.Errorf("no recipients are specified")
+	}
+
+	for _, r := range e.Age {
+		if _, err := age.ParseX25519Recipient(r); err != nil {
+			return fmt.Errorf("invalid age recipient %s: %v", r, err)
+		}
+	}
+
+	for _, k := range e.PGP {
+		if _, err := openpgp.ReadArmoredKeyRing(strings.NewReader(k)); err != nil {
+			return fmt.Errorf("invalid pgp public key: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Encrypt encrypts the values in data and stringData of the Secret manifest.
+// The manifests of the other kinds are returned as they are.
+func (e *Encryption) Encrypt(manifest []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(manifest, &doc); err != nil {
+		return nil, err
+	}
+
+	if doc["apiVersion"] != "v1" || doc["kind"] != "Secret" {
+		return manifest, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	// the MAC covers the plaintext values
+	digest := sopsMAC(doc)
+
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := doc[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for k, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s.%s is not a string", field, k)
+			}
+
+			enc, err := encryptValue(dataKey, s, field+":"+k+":")
+			if err != nil {
+				return nil, err
+			}
+			values[k] = enc
+		}
+	}
+
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	mac, err := encryptValue(dataKey, digest, lastModified)
+	if err != nil {
+		return nil, err
+	}
+
+	ages := []interface{}{}
+	for _, r := range e.Age {
+		enc, err := encryptForAge(r, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		ages = append(ages, map[string]interface{}{
+			"recipient": r,
+			"enc":       enc,
+		})
+	}
+
+	pgps := []interface{}{}
+	for _, k := range e.PGP {
+		fp, enc, err := encryptForPGP(k, dataKey)
+		if err != nil {
+			return nil, err
+		}
+		pgps = append(pgps, map[string]interface{}{
+			"fp":         fp,
+			"created_at": lastModified,
+			"enc":        enc,
+		})
+	}
+
+	metadata := map[string]interface{}{
+		"lastmodified":    lastModified,
+		"mac":             mac,
+		"encrypted_regex": sopsEncryptedRegex,
+		"version":         sopsVersion,
+	}
+	if len(ages) > 0 {
+		metadata["age"] = ages
+	}
+	if len(pgps) > 0 {
+		metadata["pgp"] = pgps
+	}
+	doc["sops"] = metadata
+
+	return yaml.Marshal(doc)
+}
+
+// encryptValue encrypts the value with AES256-GCM in the format of SOPS
+func encryptValue(key []byte, value, additionalData string) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCMWithNonceSize(block, 32)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, 32)
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nil, iv, []byte(value), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:str]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+	), nil
+}
+
+// sopsMAC computes the SHA-512 hash of the plaintext values in the order of the marshaled document
+func sopsMAC(doc map[string]interface{}) string {
+	h := sha512.New()
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(v[k])
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		case string:
+			h.Write([]byte(v))
+		case float64:
+			h.Write([]byte(strconv.FormatFloat(v, 'f', -1, 64)))
+		case bool:
+			if v {
+				h.Write([]byte("True"))
+			} else {
+				h.Write([]byte("False"))
+			}
+		}
+	}
+	walk(doc)
+
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
+
+// encryptForPGP encrypts the data key for the PGP public key, returning its fingerprint and the armored message
+func encryptForPGP(key string, dataKey []byte) (string, string, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+	if err != nil {
+		return "", "", err
+	}
+	if len(entities) == 0 {
+		return "", "", fmt.Errorf("no pgp public key is found")
+	}
+
+	var b bytes.Buffer
+	aw, err := armor.Encode(&b, "PGP MESSAGE", nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	// the data key is binary, which the text literal data would alter on decryption
+	pw, err := openpgp.Encrypt(aw, entities[:1], nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = pw.Write(dataKey); err != nil {
+		return "", "", err
+	}
+	if err = pw.Close(); err != nil {
+		return "", "", err
+	}
+	if err = aw.Close(); err != nil {
+		return "", "", err
+	}
+	b.WriteString("\n")
+
+	fp := strings.ToUpper(hex.EncodeToString(entities[0].PrimaryKey.Fingerprint[:]))
+	return fp, b.String(), nil
+}
+
+// encryptForAge encrypts the data key for the age X25519 recipient as an armored age file
+func encryptForAge(recipient string, dataKey []byte) (string, error) {
+	r, err := age.ParseX25519Recipient(recipient)
+	if err != nil {
+		return "", fmt.Errorf("invalid age recipient %s: %v", recipient, err)
+	}
+
+	var b bytes.Buffer
+	aw := agearmor.NewWriter(&b)
+	w, err := age.Encrypt(aw, r)
+	if err != nil {
+		return "", err
+	}
+	if _, err = w.Write(dataKey); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+	if err = aw.Close(); err != nil {
+		return "", err
+	}
+	b.WriteString("\n")
+
+	return b.String(), nil
+}
@@ -0,0 +1,214 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+	agearmor "filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+)
+
+// sopsValue is the format of the values encrypted by SOPS
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:str\]$`)
+
+// decryptSOPSValue decrypts the value as `sops -d` does
+func decryptSOPSValue(dataKey []byte, value, additionalData string) string {
+	m := sopsValue.FindStringSubmatch(value)
+	Expect(m).NotTo(BeNil(), value)
+
+	data, err := base64.StdEncoding.DecodeString(m[1])
+	Expect(err).NotTo(HaveOccurred())
+	iv, err := base64.StdEncoding.DecodeString(m[2])
+	Expect(err).NotTo(HaveOccurred())
+	tag, err := base64.StdEncoding.DecodeString(m[3])
+	Expect(err).NotTo(HaveOccurred())
+
+	block, err := aes.NewCipher(dataKey)
+	Expect(err).NotTo(HaveOccurred())
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	Expect(err).NotTo(HaveOccurred())
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	Expect(err).NotTo(HaveOccurred())
+	return string(plaintext)
+}
+
+var _ = Describe("Encryption", func() {
+	const secret = `apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+  namespace: default
+type: Opaque
+data:
+  password: c2VjcmV0
+stringData:
+  username: admin
+`
+
+	var (
+		identity   *age.X25519Identity
+		entity     *openpgp.Entity
+		encryption *Encryption
+	)
+
+	BeforeEach(func() {
+		var err error
+		identity, err = age.GenerateX25519Identity()
+		Expect(err).NotTo(HaveOccurred())
+
+		entity, err = openpgp.NewEntity("manifest-capturer", "test", "capturer@example.com", nil)
+		Expect(err).NotTo(HaveOccurred())
+		var public bytes.Buffer
+		w, err := armor.Encode(&public, openpgp.PublicKeyType, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entity.Serialize(w)).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		encryption = &Encryption{
+			Age: []string{identity.Recipient().String()},
+			PGP: []string{public.String()},
+		}
+	})
+
+	// encrypt encrypts the Secret and returns it with its sops metadata
+	encrypt := func() (map[string]interface{}, map[string]interface{}) {
+		Expect(encryption.Validate()).To(Succeed())
+		encrypted, err := encryption.Encrypt([]byte(secret))
+		Expect(err).NotTo(HaveOccurred())
+
+		var doc map[string]interface{}
+		Expect(yaml.Unmarshal(encrypted, &doc)).To(Succeed())
+		metadata, ok := doc["sops"].(map[string]interface{})
+		Expect(ok).To(BeTrue())
+		return doc, metadata
+	}
+
+	It("encrypts the data key for the age recipient", func() {
+		_, metadata := encrypt()
+		ages := metadata["age"].([]interface{})
+		Expect(ages).To(HaveLen(1))
+		recipient := ages[0].(map[string]interface{})
+		Expect(recipient["recipient"]).To(Equal(identity.Recipient().String()))
+
+		r, err := age.Decrypt(agearmor.NewReader(strings.NewReader(recipient["enc"].(string))), identity)
+		Expect(err).NotTo(HaveOccurred())
+		dataKey, err := ioutil.ReadAll(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dataKey).To(HaveLen(32))
+	})
+
+	It("encrypts the values SOPS-style, which are decrypted by the data key of either recipient", func() {
+		doc, metadata := encrypt()
+
+		r, err := age.Decrypt(agearmor.NewReader(strings.NewReader(metadata["age"].([]interface{})[0].(map[string]interface{})["enc"].(string))), identity)
+		Expect(err).NotTo(HaveOccurred())
+		dataKey, err := ioutil.ReadAll(r)
+		Expect(err).NotTo(HaveOccurred())
+
+		pgps := metadata["pgp"].([]interface{})
+		Expect(pgps).To(HaveLen(1))
+		pgp := pgps[0].(map[string]interface{})
+		Expect(pgp["fp"]).To(Equal(strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]))))
+		block, err := armor.Decode(strings.NewReader(pgp["enc"].(string)))
+		Expect(err).NotTo(HaveOccurred())
+		md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{entity}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		pgpDataKey, err := ioutil.ReadAll(md.UnverifiedBody)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pgpDataKey).To(Equal(dataKey))
+
+		data := doc["data"].(map[string]interface{})
+		stringData := doc["stringData"].(map[string]interface{})
+		Expect(decryptSOPSValue(dataKey, data["password"].(string), "data:password:")).To(Equal("c2VjcmV0"))
+		Expect(decryptSOPSValue(dataKey, stringData["username"].(string), "stringData:username:")).To(Equal("admin"))
+		Expect(doc["metadata"].(map[string]interface{})["name"]).To(Equal("credentials"))
+		Expect(doc["type"]).To(Equal("Opaque"))
+
+		// the MAC is the hash of the plaintext values, encrypted with lastmodified
+		data["password"] = "c2VjcmV0"
+		stringData["username"] = "admin"
+		delete(doc, "sops")
+		mac := decryptSOPSValue(dataKey, metadata["mac"].(string), metadata["lastmodified"].(string))
+		Expect(mac).To(Equal(sopsMAC(doc)))
+		Expect(metadata["encrypted_regex"]).To(Equal(sopsEncryptedRegex))
+	})
+
+	It("is decrypted by gpg", func() {
+		gpg, err := exec.LookPath("gpg")
+		if err != nil {
+			Skip("gpg is not installed")
+		}
+
+		home, err := ioutil.TempDir("", "gnupg")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		var private bytes.Buffer
+		w, err := armor.Encode(&private, openpgp.PrivateKeyType, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entity.SerializePrivate(w, nil)).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+		keyFile := filepath.Join(home, "private.asc")
+		Expect(ioutil.WriteFile(keyFile, private.Bytes(), 0600)).To(Succeed())
+		out, err := exec.Command(gpg, "--homedir", home, "--batch", "--import", keyFile).CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(out))
+
+		_, metadata := encrypt()
+		enc := metadata["pgp"].([]interface{})[0].(map[string]interface{})["enc"].(string)
+		cmd := exec.Command(gpg, "--homedir", home, "--batch", "--quiet", "--decrypt")
+		cmd.Stdin = strings.NewReader(enc)
+		gpgDataKey, err := cmd.Output()
+		Expect(err).NotTo(HaveOccurred())
+
+		block, err := armor.Decode(strings.NewReader(enc))
+		Expect(err).NotTo(HaveOccurred())
+		md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{entity}, nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		dataKey, err := ioutil.ReadAll(md.UnverifiedBody)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gpgDataKey).To(Equal(dataKey))
+	})
+
+	It("leaves the other kinds as they are", func() {
+		manifest := []byte("apiVersion: v1\nkind: ConfigMap\ndata:\n  key: value\n")
+		encrypted, err := encryption.Encrypt(manifest)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encrypted).To(Equal(manifest))
+	})
+
+	It("rejects the invalid recipients", func() {
+		Expect((&Encryption{}).Validate()).NotTo(Succeed())
+		Expect((&Encryption{Age: []string{"age1invalid"}}).Validate()).NotTo(Succeed())
+		Expect((&Encryption{PGP: []string{"not a key"}}).Validate()).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,232 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	nethttp "net/http"
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Keys of the Secret referred by secretRef of GitHubConfig
+const (
+	// SecretKeyToken is the key of the personal access token or the installation token of GitHub App
+	SecretKeyToken = "token"
+
+	// SecretKeyUsername and SecretKeyPassword are the keys of the basic authentication,
+	// which are the same as the Secrets of type `kubernetes.io/basic-auth`
+	SecretKeyUsername = "username"
+	SecretKeyPassword = "password"
+
+	// SecretKeySSHPrivateKey is the key of the SSH private key, e.g. a deploy key,
+	// which is the same as the Secrets of type `kubernetes.io/ssh-auth`
+	SecretKeySSHPrivateKey = "ssh-privatekey"
+
+	// SecretKeyPassphrase is the key of the passphrase of the encrypted SSH private key
+	SecretKeyPassphrase = "passphrase"
+
+	// SecretKeyKnownHosts is the key of the known_hosts verifying the host key of the SSH server
+	SecretKeyKnownHosts = "known_hosts"
+)
+
+// tokenUsername is the username of the HTTP basic authentication with a token, which GitHub ignores
+const tokenUsername = "x-access-token"
+
+// GitCredentials are the credentials of the Git repository, resolved from the Secret referred by secretRef
+// +kubebuilder:object:generate=false
+type GitCredentials struct {
+	// Token is the token sent as the password of the basic authentication and the token of the GitHub REST API
+	Token string
+
+	// Username and Password are sent by the basic authentication
+	Username string
+	Password string
+
+	// SSHPrivateKey is the private key of the SSH transport, either ed25519, RSA or ECDSA
+	// in the OpenSSH, PKCS#1, PKCS#8 or SEC 1 format
+	SSHPrivateKey []byte
+
+	// Passphrase decrypts SSHPrivateKey if it is encrypted
+	Passphrase string
+
+	// KnownHosts is the known_hosts verifying the host key of the SSH server
+	KnownHosts []byte
+}
+
+// NewGitCredentials reads the credentials from the data of the Secret
+func NewGitCredentials(data map[string][]byte) (*GitCredentials, error) {
+	c := &GitCredentials{
+		Token:    string(data[SecretKeyToken]),
+		Username: string(data[SecretKeyUsername]),
+		Password: string(data[SecretKeyPassword]),
+
+		SSHPrivateKey: data[SecretKeySSHPrivateKey],
+		Passphrase:    string(data[SecretKeyPassphrase]),
+		KnownHosts:    data[SecretKeyKnownHosts],
+	}
+
+	switch {
+	case len(c.SSHPrivateKey) > 0:
+		if len(c.KnownHosts) == 0 {
+			return nil, fmt.Errorf("%s is required to verify the host key for %s", SecretKeyKnownHosts, SecretKeySSHPrivateKey)
+		}
+		return c, nil
+	case c.Token != "":
+		return c, nil
+	case c.Username != "" && c.Password != "":
+		return c, nil
+	case c.Username != "" || c.Password != "":
+		return nil, fmt.Errorf("both %s and %s are required for basic authentication", SecretKeyUsername, SecretKeyPassword)
+	}
+	return nil, fmt.Errorf("none of %s, %s and %s, or %s is found", SecretKeyToken, SecretKeyUsername, SecretKeyPassword, SecretKeySSHPrivateKey)
+}
+
+// credentials returns the credentials resolved from secretRef, or the ones of GITHUB_ACCESS_TOKEN.
+// It returns nil if there is neither.
+func (c *GitHubConfig) credentials(resolved *GitCredentials) (*GitCredentials, error) {
+	if c.SecretRef != nil {
+		if resolved == nil {
+			return nil, &OutputError{
+				Reason: ReasonInvalidCredentials,
+				Err:    errors.New("credentials of secretRef are not resolved"),
+			}
+		}
+		return resolved, nil
+	}
+
+	if personalAccessToken != "" {
+		return &GitCredentials{Token: personalAccessToken}, nil
+	}
+	return nil, nil
+}
+
+// auth returns the auth method of the Git transport, which is chosen by the scheme of RepositoryURL.
+// It returns nil for the public repositories accessed without credentials over HTTP(S).
+func (c *GitHubConfig) auth(resolved *GitCredentials) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(c.RepositoryURL)
+	if err != nil {
+		return nil, &OutputError{Reason: ReasonInvalidSpec, Err: err}
+	}
+
+	creds, err := c.credentials(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	if ep.Protocol == "ssh" {
+		if creds == nil || len(creds.SSHPrivateKey) == 0 {
+			return nil, &OutputError{
+				Reason: ReasonInvalidCredentials,
+				Err:    fmt.Errorf("%s of secretRef is required for SSH repository %s", SecretKeySSHPrivateKey, c.RepositoryURL),
+			}
+		}
+		return sshAuth(ep, creds)
+	}
+
+	if creds == nil {
+		return nil, nil
+	}
+	if len(creds.SSHPrivateKey) > 0 {
+		return nil, &OutputError{
+			Reason: ReasonInvalidCredentials,
+			Err:    fmt.Errorf("%s cannot be used for %s repository %s", SecretKeySSHPrivateKey, ep.Protocol, c.RepositoryURL),
+		}
+	}
+	if creds.Token != "" {
+		return &http.BasicAuth{Username: tokenUsername, Password: creds.Token}, nil
+	}
+	return &http.BasicAuth{Username: creds.Username, Password: creds.Password}, nil
+}
+
+// sshAuth returns the auth method of the SSH transport by the private key, verifying the host key by known_hosts
+func sshAuth(ep *transport.Endpoint, creds *GitCredentials) (transport.AuthMethod, error) {
+	user := ep.User
+	if user == "" {
+		user = gitssh.DefaultUsername
+	}
+
+	// the keys encrypted by ssh-keygen are parsed here since gitssh.NewPublicKeys only decrypts PEM encryption
+	var signer ssh.Signer
+	var err error
+	if creds.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(creds.SSHPrivateKey, []byte(creds.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(creds.SSHPrivateKey)
+	}
+	if err != nil {
+		return nil, &OutputError{
+			Reason: ReasonInvalidCredentials,
+			Err:    fmt.Errorf("invalid %s: %w", SecretKeySSHPrivateKey, err),
+		}
+	}
+
+	callback, err := knownHostsCallback(creds.KnownHosts)
+	if err != nil {
+		return nil, &OutputError{
+			Reason: ReasonInvalidCredentials,
+			Err:    fmt.Errorf("invalid %s: %w", SecretKeyKnownHosts, err),
+		}
+	}
+
+	auth := &gitssh.PublicKeys{User: user, Signer: signer}
+	auth.HostKeyCallback = callback
+	return auth, nil
+}
+
+// knownHostsCallback returns the callback verifying the host key by known_hosts.
+// knownhosts only reads files, so known_hosts is written in a temporary file while it is parsed.
+func knownHostsCallback(knownHosts []byte) (ssh.HostKeyCallback, error) {
+	f, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(knownHosts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return knownhosts.New(f.Name())
+}
+
+// authorize sets the credentials to the request of the GitHub REST API
+func (c *GitHubConfig) authorize(req *nethttp.Request, resolved *GitCredentials) error {
+	creds, err := c.credentials(resolved)
+	if err != nil || creds == nil {
+		return err
+	}
+
+	switch {
+	case creds.Token != "":
+		req.Header.Set("Authorization", "token "+creds.Token)
+	case creds.Username != "":
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("GitCredentials", func() {
+	var config GitHubConfig
+
+	BeforeEach(func() {
+		config = GitHubConfig{
+			RepositoryURL: "https://github.com/terakoya76/manifests.git",
+			SecretRef:     &corev1.LocalObjectReference{Name: "github-credentials"},
+		}
+	})
+
+	It("authenticates with the token", func() {
+		creds, err := NewGitCredentials(map[string][]byte{"token": []byte("ghp_token")})
+		Expect(err).NotTo(HaveOccurred())
+		auth, err := config.auth(creds)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auth).To(Equal(&http.BasicAuth{Username: "x-access-token", Password: "ghp_token"}))
+	})
+
+	It("authenticates with the username and the password", func() {
+		creds, err := NewGitCredentials(map[string][]byte{"username": []byte("bot"), "password": []byte("hunter2")})
+		Expect(err).NotTo(HaveOccurred())
+		auth, err := config.auth(creds)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auth).To(Equal(&http.BasicAuth{Username: "bot", Password: "hunter2"}))
+	})
+
+	It("rejects the Secret without the credentials", func() {
+		_, err := NewGitCredentials(map[string][]byte{"username": []byte("bot")})
+		Expect(err).To(MatchError(ContainSubstring("both username and password are required")))
+
+		_, err = NewGitCredentials(map[string][]byte{"other": []byte("value")})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("refuses to fall back when the credentials of secretRef are not resolved", func() {
+		_, err := config.auth(nil)
+		Expect(ErrorReason(err, "")).To(Equal(ReasonInvalidCredentials))
+	})
+
+	It("falls back to GITHUB_ACCESS_TOKEN without secretRef", func() {
+		defer func(token string) { personalAccessToken = token }(personalAccessToken)
+		config.SecretRef = nil
+
+		personalAccessToken = ""
+		auth, err := config.auth(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auth).To(BeNil())
+
+		personalAccessToken = "ghp_env"
+		auth, err = config.auth(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auth).To(Equal(&http.BasicAuth{Username: "x-access-token", Password: "ghp_env"}))
+	})
+})
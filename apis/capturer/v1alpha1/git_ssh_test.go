@@ -0,0 +1,322 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// gitSSHServer serves the Git repositories over SSH by running git-upload-pack and git-receive-pack,
+// accepting only the authorized key
+type gitSSHServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.Signer
+}
+
+func startGitSSHServer(authorized ssh.PublicKey) *gitSSHServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	Expect(err).NotTo(HaveOccurred())
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, errors.New("unauthorized key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+
+	s := &gitSSHServer{listener: listener, config: config, hostKey: hostKey}
+	go s.serve()
+	return s
+}
+
+// url returns the SSH URL of the repository at the path
+func (s *gitSSHServer) url(path string) string {
+	return fmt.Sprintf("ssh://git@%s%s", s.listener.Addr(), path)
+}
+
+// knownHosts returns the known_hosts having the host key of the server
+func (s *gitSSHServer) knownHosts() []byte {
+	addr := knownhosts.Normalize(s.listener.Addr().String())
+	return []byte(knownhosts.Line([]string{addr}, s.hostKey.PublicKey()) + "\n")
+}
+
+func (s *gitSSHServer) close() {
+	s.listener.Close()
+}
+
+func (s *gitSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *gitSSHServer) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only session is supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.exec(channel, requests)
+	}
+}
+
+// exec runs the Git command requested on the channel, e.g. `git-upload-pack '/path/to/repo.git'`
+func (s *gitSSHServer) exec(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			_ = req.Reply(false, nil)
+			return
+		}
+		args := strings.SplitN(payload.Command, " ", 2)
+		if len(args) != 2 || (args[0] != "git-upload-pack" && args[0] != "git-receive-pack") {
+			_ = req.Reply(false, nil)
+			return
+		}
+		_ = req.Reply(true, nil)
+
+		cmd := exec.Command(args[0], strings.Trim(args[1], "'"))
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		stdin, err := cmd.StdinPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			return
+		}
+		// the copy is not waited for since the client keeps the channel open after the command exits
+		go func() {
+			_, _ = io.Copy(stdin, channel)
+			stdin.Close()
+		}()
+
+		status := uint32(0)
+		if err = cmd.Wait(); err != nil {
+			status = 1
+		}
+		_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+// generateDeployKey returns the private key in PEM and its public key, either ed25519 or RSA
+func generateDeployKey(keyType string) ([]byte, ssh.PublicKey) {
+	var block *pem.Block
+	var public interface{}
+	switch keyType {
+	case "ed25519":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		Expect(err).NotTo(HaveOccurred())
+		block, public = &pem.Block{Type: "PRIVATE KEY", Bytes: der}, pub
+	case "rsa":
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		block, public = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}, &priv.PublicKey
+	}
+
+	pub, err := ssh.NewPublicKey(public)
+	Expect(err).NotTo(HaveOccurred())
+	return pem.EncodeToMemory(block), pub
+}
+
+var _ = Describe("GitHubOutput over SSH", func() {
+	var (
+		dir      string
+		remote   string
+		output   *GitHubOutput
+		snapshot *Snapshot
+	)
+
+	// setupRemote serves the remote repository having an initial commit by the server authorizing the key
+	setupRemote := func(authorized ssh.PublicKey) *gitSSHServer {
+		server := startGitSSHServer(authorized)
+
+		_, err := git.PlainInit(remote, true)
+		Expect(err).NotTo(HaveOccurred())
+		human, err := git.PlainInit(filepath.Join(dir, "human"), false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = human.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+		Expect(err).NotTo(HaveOccurred())
+		commitFile(human, "master", "README.md", "# manifests\n", "initial commit")
+
+		output.Config.RepositoryURL = server.url(remote)
+		return server
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "github-output-ssh")
+		Expect(err).NotTo(HaveOccurred())
+		remote = filepath.Join(dir, "remote.git")
+
+		output = &GitHubOutput{
+			Config: GitHubConfig{
+				BaseBranch:   "master",
+				ManifestPath: "{{ .Namespace }}/{{ .Kind }}/{{ .Name }}.yaml",
+				Author:       Author{Name: "manifest-capturer", Email: "capturer@example.com"},
+				SecretRef:    &corev1.LocalObjectReference{Name: "deploy-key"},
+				Mode:         GitHubModeDirect,
+			},
+			LocalFilePath: filepath.Join(dir, "local"),
+		}
+		snapshot = &Snapshot{
+			Object:   corev1.ObjectReference{Kind: "Deployment", Namespace: "kube-system", Name: "coredns"},
+			Manifest: []byte("spec:\n  replicas: 3\n"),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	for _, keyType := range []string{"ed25519", "rsa"} {
+		keyType := keyType
+
+		It(fmt.Sprintf("clones and pushes with the %s deploy key", keyType), func() {
+			key, pub := generateDeployKey(keyType)
+			server := setupRemote(pub)
+			defer server.close()
+
+			creds, err := NewGitCredentials(map[string][]byte{
+				"ssh-privatekey": key,
+				"known_hosts":    server.knownHosts(),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.Setup(creds)).To(Succeed())
+			_, err = output.Publish("deployment-github-output", snapshot, creds)
+			Expect(err).NotTo(HaveOccurred())
+
+			head := remoteHead(remote, "master")
+			_, err = head.File("kube-system/Deployment/coredns.yaml")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	}
+
+	It("rejects the unknown host key", func() {
+		key, pub := generateDeployKey("ed25519")
+		server := setupRemote(pub)
+		defer server.close()
+
+		other := startGitSSHServer(pub)
+		defer other.close()
+
+		addr := knownhosts.Normalize(server.listener.Addr().String())
+		knownHosts := knownhosts.Line([]string{addr}, other.hostKey.PublicKey())
+		creds := &GitCredentials{SSHPrivateKey: key, KnownHosts: []byte(knownHosts)}
+
+		err := output.Setup(creds)
+		Expect(ErrorReason(err, "")).To(Equal(ReasonAuthFailed))
+		Expect(err.Error()).To(ContainSubstring("knownhosts: key mismatch"))
+	})
+
+	It("is rejected with the key not authorized by the server", func() {
+		_, pub := generateDeployKey("ed25519")
+		server := setupRemote(pub)
+		defer server.close()
+
+		key, _ := generateDeployKey("ed25519")
+		creds := &GitCredentials{SSHPrivateKey: key, KnownHosts: server.knownHosts()}
+
+		err := output.Setup(creds)
+		Expect(ErrorReason(err, "")).To(Equal(ReasonAuthFailed))
+	})
+
+	It("selects the auth method by the scheme of the repository URL", func() {
+		key, _ := generateDeployKey("ed25519")
+		sshCreds := &GitCredentials{SSHPrivateKey: key, KnownHosts: []byte("github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl\n")}
+
+		config := GitHubConfig{
+			RepositoryURL: "git@github.com:terakoya76/manifests.git",
+			SecretRef:     &corev1.LocalObjectReference{Name: "deploy-key"},
+		}
+		auth, err := config.auth(sshCreds)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auth).To(BeAssignableToTypeOf(&gitssh.PublicKeys{}))
+		Expect(auth.(*gitssh.PublicKeys).User).To(Equal("git"))
+
+		// the SSH URL needs the key
+		_, err = config.auth(&GitCredentials{Token: "ghp_token"})
+		Expect(ErrorReason(err, "")).To(Equal(ReasonInvalidCredentials))
+
+		// the key cannot be used over HTTPS
+		config.RepositoryURL = "https://github.com/terakoya76/manifests.git"
+		_, err = config.auth(sshCreds)
+		Expect(ErrorReason(err, "")).To(Equal(ReasonInvalidCredentials))
+	})
+
+	It("requires known_hosts along with the key", func() {
+		key, _ := generateDeployKey("ed25519")
+		_, err := NewGitCredentials(map[string][]byte{"ssh-privatekey": key})
+		Expect(err).To(MatchError(ContainSubstring("known_hosts is required")))
+	})
+})
@@ -17,17 +17,27 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	corev1 "k8s.io/api/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -37,8 +47,13 @@ var (
 
 	personalAccessToken string
 	mu                  sync.Mutex
+
+	invalidBranchChars = regexp.MustCompile(`[^a-z0-9._-]+`)
 )
 
+// directPushAttempts is the number of the attempts to push onto the base branch in the direct mode
+const directPushAttempts = 5
+
 func init() {
 	once.Do(func() {
 		personalAccessToken = os.Getenv("GITHUB_ACCESS_TOKEN")
@@ -68,6 +83,10 @@ type GitHubConfig struct {
 
 	BaseBranch string `json:"baseBranch"`
 
+	// ManifestPath is the path of the manifest file in the repository.
+	// It is a text/template rendered with the captured object reference,
+	// e.g. `{{ .Namespace }}/{{ .Kind }}/{{ .Name }}.yaml`.
+	// It must render a different file for each of the objects targeted by the Capturers.
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:Format:=string
 
@@ -76,8 +95,45 @@ type GitHubConfig struct {
 	// +kubebuilder:validation:Required
 
 	Author Author `json:"author"`
+
+	// SecretRef refers the Secret in the namespace of the Output holding the credentials of the repository,
+	// either `token`, `username` and `password`, or `ssh-privatekey` and `known_hosts` for the SSH repositoryUrl.
+	// GITHUB_ACCESS_TOKEN of the manager is used if omitted.
+	// +kubebuilder:validation:Optional
+
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// Encryption encrypts the values of captured Secrets for the recipients before committing them.
+	// +kubebuilder:validation:Optional
+
+	Encryption *Encryption `json:"encryption,omitempty"`
+
+	// Mode is how the changes are committed.
+	// `branch` pushes a new branch per change, and `direct` commits straight onto BaseBranch.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=branch;direct
+	// +kubebuilder:default=branch
+
+	Mode GitHubMode `json:"mode,omitempty"`
+
+	// PullRequest opens a pull request of the branch of each change against BaseBranch.
+	// It is only available in the branch mode.
+	// +kubebuilder:validation:Optional
+
+	PullRequest *PullRequestConfig `json:"pullRequest,omitempty"`
 }
 
+// GitHubMode defines how the changes are committed to the repository
+type GitHubMode string
+
+const (
+	// GitHubModeBranch pushes a new branch per change
+	GitHubModeBranch GitHubMode = "branch"
+
+	// GitHubModeDirect commits the changes onto the base branch
+	GitHubModeDirect GitHubMode = "direct"
+)
+
 type Author struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:Format:=string
@@ -90,8 +146,27 @@ type Author struct {
 	Email string `json:"email"`
 }
 
-func (o *GitHubOutput) Setup() error {
-	if err := o.clone(); err != nil {
+// Setup verifies the repository is reachable with the credentials resolved from SecretRef,
+// which are nil if SecretRef is omitted
+func (o *GitHubOutput) Setup(creds *GitCredentials) error {
+	if e := o.Config.Encryption; e != nil {
+		if err := e.Validate(); err != nil {
+			return &OutputError{Reason: ReasonInvalidSpec, Err: err}
+		}
+	}
+	if o.Config.PullRequest != nil {
+		if o.Config.Mode == GitHubModeDirect {
+			return &OutputError{
+				Reason: ReasonInvalidSpec,
+				Err:    errors.New("pullRequest cannot be used in the direct mode"),
+			}
+		}
+		if _, _, err := o.Config.repository(); err != nil {
+			return &OutputError{Reason: ReasonInvalidSpec, Err: err}
+		}
+	}
+
+	if err := o.clone(creds); err != nil {
 		return err
 	}
 
@@ -107,58 +182,286 @@ func (o *GitHubOutput) Setup() error {
 	return o.checkout(r, bb)
 }
 
-func (o *GitHubOutput) Publish(name string, manifest []byte) (err error) {
+// Publish commits the snapshot with the credentials resolved from SecretRef
+func (o *GitHubOutput) Publish(name string, snapshot *Snapshot, creds *GitCredentials) (pub *Publication, err error) {
 	r, err := o.open()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	mu.Lock()
 	defer mu.Unlock()
 
-	if err = o.pull(r); err != nil {
-		return err
+	if err = o.pull(r, creds); err != nil {
+		return nil, err
+	}
+
+	if o.Config.Mode == GitHubModeDirect {
+		return o.publishDirect(r, name, snapshot, creds)
+	}
+
+	if snapshot.Deleted {
+		exists, err := o.manifestExists(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		// nothing to delete since the object has never been captured
+		if !exists {
+			return &Publication{}, nil
+		}
 	}
 
-	nb := fmt.Sprintf("manifest-capturer-%s", generateTimestamp())
+	nb := fmt.Sprintf("manifest-capturer-%s-%s", branchHash(snapshot), branchSuffix(snapshot))
 	bb := o.Config.BaseBranch
 	if err = o.branch(r, nb); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err = o.checkout(r, nb); err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
-		err = o.checkout(r, bb)
+		if cerr := o.checkout(r, bb); err == nil {
+			err = cerr
+		}
 	}()
 
-	if err = o.commit(r, name, manifest); err != nil {
+	if err = o.commit(r, name, snapshot); err != nil {
+		return nil, err
+	}
+
+	// the branch of the change is forced, since it has been pushed with another commit
+	// if the publication is retried after the push
+	if err = o.push(r, nb, true, creds); err != nil {
+		return nil, err
+	}
+
+	if o.Config.PullRequest == nil {
+		return &Publication{}, nil
+	}
+
+	url, err := o.openPullRequest(nb, name, snapshot, creds)
+	if err != nil {
+		return nil, err
+	}
+	return &Publication{URL: url}, nil
+}
+
+// publishDirect commits the snapshot onto the base branch and pushes it.
+// When the push is rejected since the base branch has been moved by another replica or human,
+// the commit is made again on the fetched base branch.
+func (o *GitHubOutput) publishDirect(r *git.Repository, name string, snapshot *Snapshot, creds *GitCredentials) (*Publication, error) {
+	bb := o.Config.BaseBranch
+	var err error
+	for attempt := 1; attempt <= directPushAttempts; attempt++ {
+		if snapshot.Deleted {
+			exists, err := o.manifestExists(snapshot)
+			if err != nil {
+				return nil, err
+			}
+			// nothing to delete since the object has never been captured or already deleted
+			if !exists {
+				return &Publication{}, nil
+			}
+		}
+
+		if err = o.commit(r, name, snapshot); err != nil {
+			o.discardLocalCommits(r, creds)
+			return nil, err
+		}
+
+		if err = o.push(r, bb, false, creds); err == nil {
+			return &Publication{}, nil
+		}
+
+		// the local commit is always discarded so that the base branch follows the remote one
+		if rerr := o.discardLocalCommits(r, creds); rerr != nil {
+			return nil, rerr
+		}
+		if !isPushRejected(err) {
+			return nil, err
+		}
+		githubOutputLog.Info("retrying push rejected since the base branch has moved", "branch", bb, "attempt", attempt)
+	}
+
+	return nil, err
+}
+
+// discardLocalCommits fetches the base branch and resets the local one to it
+func (o *GitHubOutput) discardLocalCommits(r *git.Repository, creds *GitCredentials) error {
+	auth, err := o.Config.auth(creds)
+	if err != nil {
+		return err
+	}
+
+	if err = r.Fetch(&git.FetchOptions{RemoteName: "origin", Auth: auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+		githubOutputLog.Error(err, "failed `git fetch origin`")
+		return gitError(ReasonFetchFailed, err)
+	}
+
+	bb := o.Config.BaseBranch
+	ref, err := r.Reference(plumbing.NewRemoteReferenceName("origin", bb), true)
+	if err != nil {
+		githubOutputLog.Error(err, "failed to resolve remote branch", "branch", bb)
+		return err
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		githubOutputLog.Error(err, "failed to open worktree")
 		return err
 	}
 
-	if err = o.push(r); err != nil {
+	if err = w.Reset(&git.ResetOptions{Commit: ref.Hash(), Mode: git.HardReset}); err != nil {
+		githubOutputLog.Error(err, "failed `git reset --hard origin/<branch>`", "branch", bb)
 		return err
 	}
 
 	return nil
 }
 
-func (o *GitHubOutput) clone() error {
+// isPushRejected reports whether the push was rejected since the remote branch has moved
+func isPushRejected(err error) bool {
+	if errors.Is(err, git.ErrForceNeeded) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "non-fast-forward") || strings.Contains(msg, "fetch first")
+}
+
+// Fetch returns the manifest of the object in the snapshot with the hash of its commit
+func (o *GitHubOutput) Fetch(ref SnapshotReference, object corev1.ObjectReference, creds *GitCredentials) ([]byte, string, error) {
+	r, err := o.open()
+	if err != nil {
+		return nil, "", err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	auth, err := o.Config.auth(creds)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = r.Fetch(&git.FetchOptions{RemoteName: "origin", Auth: auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+		githubOutputLog.Error(err, "failed `git fetch origin`")
+		return nil, "", gitError(ReasonFetchFailed, err)
+	}
+
+	manifestPath, err := o.manifestPath(&Snapshot{Object: object})
+	if err != nil {
+		return nil, "", &OutputError{Reason: ReasonInvalidSpec, Err: err}
+	}
+
+	hash, err := o.resolveSnapshot(r, ref, manifestPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, "", &OutputError{Reason: ReasonSnapshotNotFound, Err: err}
+	}
+
+	file, err := commit.File(manifestPath)
+	if err != nil {
+		return nil, "", &OutputError{
+			Reason: ReasonSnapshotNotFound,
+			Err:    fmt.Errorf("%s is not found in commit %s: %w", manifestPath, hash, err),
+		}
+	}
+
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, "", err
+	}
+	return []byte(contents), hash.String(), nil
+}
+
+// resolveSnapshot returns the hash of the commit having the snapshot
+func (o *GitHubOutput) resolveSnapshot(r *git.Repository, ref SnapshotReference, manifestPath string) (plumbing.Hash, error) {
+	if ref.Commit != "" {
+		hash, err := r.ResolveRevision(plumbing.Revision(ref.Commit))
+		if err != nil {
+			return plumbing.ZeroHash, &OutputError{Reason: ReasonSnapshotNotFound, Err: err}
+		}
+		return *hash, nil
+	}
+
+	branch := ref.Branch
+	if branch == "" {
+		branch = o.Config.BaseBranch
+	}
+	head, err := r.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	if err != nil {
+		return plumbing.ZeroHash, &OutputError{
+			Reason: ReasonSnapshotNotFound,
+			Err:    fmt.Errorf("branch %s is not found: %w", branch, err),
+		}
+	}
+	if ref.Time == nil {
+		return head.Hash(), nil
+	}
+
+	until := ref.Time.Time
+	iter, err := r.Log(&git.LogOptions{
+		From:     head.Hash(),
+		FileName: &manifestPath,
+		Until:    &until,
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	defer iter.Close()
+
+	commit, err := iter.Next()
+	if err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf("no snapshot of %s before %s on %s", manifestPath, until, branch)
+		}
+		return plumbing.ZeroHash, &OutputError{Reason: ReasonSnapshotNotFound, Err: err}
+	}
+	return commit.Hash, nil
+}
+
+func (o *GitHubOutput) clone(creds *GitCredentials) error {
 	url := o.Config.RepositoryURL
 	directory := o.LocalFilePath
 
-	_, err := git.PlainClone(directory, false, &git.CloneOptions{
+	auth, err := o.Config.auth(creds)
+	if err != nil {
+		return err
+	}
+
+	_, err = git.PlainClone(directory, false, &git.CloneOptions{
 		URL:               url,
+		Auth:              auth,
 		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
 	})
 	if err != nil {
 		if err.Error() == git.ErrRepositoryAlreadyExists.Error() {
-			return nil
+			// the local clone does not tell whether the repository is still reachable with the credentials
+			return o.lsRemote(auth)
 		}
 
 		githubOutputLog.Error(err, "failed `git clone %s %s --recursive`", url, directory)
-		return err
+		return gitError(ReasonCloneFailed, err)
+	}
+
+	return nil
+}
+
+// lsRemote lists the references of the repository to verify it is reachable with the credentials
+func (o *GitHubOutput) lsRemote(auth transport.AuthMethod) error {
+	url := o.Config.RepositoryURL
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+	if _, err := remote.List(&git.ListOptions{Auth: auth}); err != nil {
+		githubOutputLog.Error(err, "failed `git ls-remote`", "url", url)
+		return gitError(ReasonFetchFailed, err)
 	}
 
 	return nil
@@ -213,24 +516,29 @@ func (o *GitHubOutput) checkout(r *git.Repository, branch string) error {
 	return nil
 }
 
-func (o *GitHubOutput) pull(r *git.Repository) error {
+func (o *GitHubOutput) pull(r *git.Repository, creds *GitCredentials) error {
 	w, err := r.Worktree()
 	if err != nil {
 		githubOutputLog.Error(err, "failed to open worktree")
 		return err
 	}
 
-	if err = w.Pull(&git.PullOptions{RemoteName: "origin"}); err != nil {
+	auth, err := o.Config.auth(creds)
+	if err != nil {
+		return err
+	}
+
+	if err = w.Pull(&git.PullOptions{RemoteName: "origin", Auth: auth}); err != nil {
 		if err != git.NoErrAlreadyUpToDate {
 			githubOutputLog.Error(err, "failed `git pull origin`")
-			return err
+			return gitError(ReasonPullFailed, err)
 		}
 	}
 
 	return nil
 }
 
-func (o *GitHubOutput) commit(r *git.Repository, name string, manifest []byte) error {
+func (o *GitHubOutput) commit(r *git.Repository, name string, snapshot *Snapshot) error {
 	w, err := r.Worktree()
 	if err != nil {
 		githubOutputLog.Error(err, "failed to open worktree")
@@ -238,8 +546,34 @@ func (o *GitHubOutput) commit(r *git.Repository, name string, manifest []byte) e
 	}
 
 	directory := o.LocalFilePath
-	manifestPath := o.Config.ManifestPath
+	manifestPath, err := o.manifestPath(snapshot)
+	if err != nil {
+		githubOutputLog.Error(err, "failed to render manifestPath", "manifestPath", o.Config.ManifestPath)
+		return err
+	}
+
 	filename := filepath.Join(directory, manifestPath)
+	if snapshot.Deleted {
+		if _, err = w.Remove(manifestPath); err != nil {
+			githubOutputLog.Error(err, "failed `git rm`", "filename", filename)
+			return err
+		}
+
+		return o.commitWithMessage(w, o.commitMessage(snapshot))
+	}
+
+	if err = os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		githubOutputLog.Error(err, "failed to create directory", "filename", filename)
+		return err
+	}
+
+	manifest := snapshot.Manifest
+	if e := o.Config.Encryption; e != nil {
+		if manifest, err = e.Encrypt(manifest); err != nil {
+			githubOutputLog.Error(err, "failed to encrypt manifest", "filename", filename)
+			return &OutputError{Reason: ReasonEncryptFailed, Err: err}
+		}
+	}
 
 	header := []byte(fmt.Sprintf("# this file is generated by manifest-capturer by %s\n\n", name))
 	content := append(header[:], manifest[:]...)
@@ -252,12 +586,31 @@ func (o *GitHubOutput) commit(r *git.Repository, name string, manifest []byte) e
 	if err != nil {
 		githubOutputLog.Error(err, "failed `git add`", "filename", filename)
 		return err
+	}
 
+	return o.commitWithMessage(w, o.commitMessage(snapshot))
+}
+
+// commitMessage returns the message of the commit of the snapshot, which also makes the title and the body of the pull request
+func (o *GitHubOutput) commitMessage(snapshot *Snapshot) string {
+	if snapshot.Deleted {
+		return fmt.Sprintf("delete manifest of %s", snapshot) + trailers(snapshot)
 	}
 
-	author := o.Config.Author
 	msg := "update manifest"
-	_, err = w.Commit(msg, &git.CommitOptions{
+	if headline := snapshot.Headline(); headline != "" {
+		msg = headline
+	}
+	if snapshot.Diff != "" {
+		added, removed := snapshot.DiffStat()
+		msg = fmt.Sprintf("%s\n\n%s%s: %d insertions(+), %d deletions(-)", msg, summaryList(snapshot, "- "), snapshot, added, removed)
+	}
+	return msg + trailers(snapshot)
+}
+
+func (o *GitHubOutput) commitWithMessage(w *git.Worktree, msg string) error {
+	author := o.Config.Author
+	_, err := w.Commit(msg, &git.CommitOptions{
 		Author: &object.Signature{
 			Name:  author.Name,
 			Email: author.Email,
@@ -272,24 +625,176 @@ func (o *GitHubOutput) commit(r *git.Repository, name string, manifest []byte) e
 	return nil
 }
 
-func (o *GitHubOutput) push(r *git.Repository) error {
-	author := o.Config.Author
-	if err := r.Push(&git.PushOptions{
-		Auth: &http.BasicAuth{
-			Username: author.Name,
-			Password: personalAccessToken,
-		},
-	}); err != nil {
-		githubOutputLog.Error(err, "failed `git push`")
+func (o *GitHubOutput) push(r *git.Repository, branch string, force bool, creds *GitCredentials) error {
+	auth, err := o.Config.auth(creds)
+	if err != nil {
 		return err
 	}
 
+	ref := plumbing.NewBranchReferenceName(branch)
+	refSpec := config.RefSpec(ref + ":" + ref)
+	if force {
+		refSpec = "+" + refSpec
+	}
+	if err = r.Push(&git.PushOptions{
+		RefSpecs: []config.RefSpec{refSpec},
+		Auth:     auth,
+	}); err != nil && err != git.NoErrAlreadyUpToDate {
+		githubOutputLog.Error(err, "failed `git push`")
+		return gitError(ReasonPushFailed, err)
+	}
+
 	return nil
 }
 
-func generateTimestamp() string {
-	t := time.Now()
-	year, month, day := t.Date()
-	hour, min, sec := t.Clock()
-	return fmt.Sprintf("%04d%02d%02d%02d%02d%02d", year, int(month), day, hour, min, sec)
+// trailers returns the git trailers of the restorability and the attribution of the change, preceded by an empty line
+func trailers(snapshot *Snapshot) string {
+	lines := []string{}
+	if rs := snapshot.Restorability; rs != nil && rs.Unknown {
+		lines = append(lines, "Restorable: unknown (no restore RBAC)")
+	} else if rs != nil {
+		lines = append(lines, fmt.Sprintf("Restorable: %t", rs.Restorable))
+		if !rs.Restorable {
+			lines = append(lines, "Restore-Error: "+strings.ReplaceAll(rs.Message, "\n", " "))
+		}
+	}
+
+	a := snapshot.Attribution
+	if a == nil {
+		if len(lines) == 0 {
+			return ""
+		}
+		return "\n\n" + strings.Join(lines, "\n")
+	}
+
+	if a.Manager != "" {
+		lines = append(lines, "Changed-By: "+a.Manager)
+	}
+	lines = append(lines,
+		"Change-Operation: "+a.Operation,
+		"Changed-At: "+a.Time.UTC().Format(time.RFC3339),
+	)
+	if a.User != "" {
+		lines = append(lines, "Requested-By: "+a.User)
+	}
+	if len(a.Groups) > 0 {
+		lines = append(lines, "Requested-Groups: "+strings.Join(a.Groups, ", "))
+	}
+	if a.UserAgent != "" {
+		lines = append(lines, "User-Agent: "+a.UserAgent)
+	}
+	if len(a.SourceIPs) > 0 {
+		lines = append(lines, "Source-IPs: "+strings.Join(a.SourceIPs, ", "))
+	}
+	return "\n\n" + strings.Join(lines, "\n")
+}
+
+// gitError wraps err of git operation with the reason, telling authentication failures apart
+func gitError(reason string, err error) error {
+	if errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed) ||
+		// the errors of the SSH handshake, e.g. the rejected key or the unknown host key, are not wrapped
+		strings.Contains(err.Error(), "ssh: handshake failed") {
+		reason = ReasonAuthFailed
+	}
+	return &OutputError{Reason: reason, Err: err}
+}
+
+// validateCapturer rejects the manifestPath rendering the same file for the different objects
+// targeted by the Capturer, which would overwrite each other, and the Secrets captured in plaintext
+// which would be committed without encryption
+func (o *GitHubOutput) validateCapturer(c *Capturer) error {
+	if c.RevealsSecrets() && o.Config.Encryption == nil {
+		return &OutputError{
+			Reason: ReasonInvalidSpec,
+			Err:    fmt.Errorf("Capturer %s captures Secrets in plaintext, which needs encryption", c.GetName()),
+		}
+	}
+
+	multipleNames := len(c.Spec.ResourceNamePatterns) > 0 || c.Spec.ResourceNameRegex != "" || c.Spec.Selector != nil
+	multipleNamespaces := c.Spec.NamespacedResource && c.Spec.ResourceNamespace == ""
+
+	ref := corev1.ObjectReference{Kind: c.Spec.ResourceKind, Namespace: "namespace-a", Name: "name-a"}
+	others := []corev1.ObjectReference{}
+	if multipleNames {
+		other := ref
+		other.Name = "name-b"
+		others = append(others, other)
+	}
+	if multipleNamespaces {
+		other := ref
+		other.Namespace = "namespace-b"
+		others = append(others, other)
+	}
+
+	path, err := o.manifestPath(&Snapshot{Object: ref})
+	if err != nil {
+		return &OutputError{Reason: ReasonInvalidSpec, Err: fmt.Errorf("invalid manifestPath: %w", err)}
+	}
+	for _, other := range others {
+		otherPath, err := o.manifestPath(&Snapshot{Object: other})
+		if err != nil {
+			return &OutputError{Reason: ReasonInvalidSpec, Err: fmt.Errorf("invalid manifestPath: %w", err)}
+		}
+		if otherPath == path {
+			return &OutputError{
+				Reason: ReasonInvalidSpec,
+				Err: fmt.Errorf("manifestPath %q renders the same file for all the objects targeted by Capturer %s, "+
+					"include {{ .Namespace }} and {{ .Name }} in it", o.Config.ManifestPath, c.GetName()),
+			}
+		}
+	}
+	return nil
+}
+
+// manifestPath renders ManifestPath with the captured object reference
+func (o *GitHubOutput) manifestPath(snapshot *Snapshot) (string, error) {
+	tmpl, err := template.New("manifestPath").Parse(o.Config.ManifestPath)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err = tmpl.Execute(&b, snapshot.Object); err != nil {
+		return "", err
+	}
+	return filepath.Clean(b.String()), nil
+}
+
+// manifestExists reports whether the manifest of the captured object is in the local repository
+func (o *GitHubOutput) manifestExists(snapshot *Snapshot) (bool, error) {
+	manifestPath, err := o.manifestPath(snapshot)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(filepath.Join(o.LocalFilePath, manifestPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// branchSuffix makes the captured object reference usable as a part of branch name
+func branchSuffix(snapshot *Snapshot) string {
+	s := strings.ToLower(strings.Join([]string{
+		snapshot.Object.Kind,
+		snapshot.Object.Namespace,
+		snapshot.Object.Name,
+	}, "-"))
+	return invalidBranchChars.ReplaceAllString(s, "-")
+}
+
+// branchHash identifies the change by the hash of the manifest, so that the retries of
+// the publication push the same branch
+func branchHash(snapshot *Snapshot) string {
+	h := sha256.New()
+	h.Write(snapshot.Manifest)
+	if snapshot.Deleted {
+		h.Write([]byte("\x00deleted"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
 }
@@ -0,0 +1,325 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// commitFile commits the file to the branch of the repository and pushes it to origin
+func commitFile(r *git.Repository, branch string, path string, content string, msg string) {
+	w, err := r.Worktree()
+	Expect(err).NotTo(HaveOccurred())
+
+	Expect(ioutil.WriteFile(filepath.Join(w.Filesystem.Root(), path), []byte(content), 0644)).To(Succeed())
+	_, err = w.Add(path)
+	Expect(err).NotTo(HaveOccurred())
+	_, err = w.Commit(msg, &git.CommitOptions{
+		Author: &object.Signature{Name: "human", Email: "human@example.com", When: time.Now()},
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	ref := plumbing.NewBranchReferenceName(branch)
+	Expect(r.Push(&git.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(ref + ":" + ref)},
+	})).To(Succeed())
+}
+
+// remoteHead returns the commit at the head of the branch of the bare repository
+func remoteHead(dir string, branch string) *object.Commit {
+	r, err := git.PlainOpen(dir)
+	Expect(err).NotTo(HaveOccurred())
+
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(branch), true)
+	Expect(err).NotTo(HaveOccurred())
+
+	commit, err := r.CommitObject(ref.Hash())
+	Expect(err).NotTo(HaveOccurred())
+	return commit
+}
+
+// remoteBranches returns the names of the branches of the bare repository
+func remoteBranches(dir string) []string {
+	r, err := git.PlainOpen(dir)
+	Expect(err).NotTo(HaveOccurred())
+
+	branches, err := r.Branches()
+	Expect(err).NotTo(HaveOccurred())
+	names := []string{}
+	Expect(branches.ForEach(func(ref *plumbing.Reference) error {
+		names = append(names, ref.Name().Short())
+		return nil
+	})).To(Succeed())
+	return names
+}
+
+var _ = Describe("GitHubOutput", func() {
+	var (
+		dir      string
+		remote   string
+		human    *git.Repository
+		output   *GitHubOutput
+		snapshot *Snapshot
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "github-output")
+		Expect(err).NotTo(HaveOccurred())
+
+		// the remote repository has an initial commit on master, and a human works on its clone
+		remote = filepath.Join(dir, "remote.git")
+		_, err = git.PlainInit(remote, true)
+		Expect(err).NotTo(HaveOccurred())
+
+		human, err = git.PlainInit(filepath.Join(dir, "human"), false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = human.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+		Expect(err).NotTo(HaveOccurred())
+		commitFile(human, "master", "README.md", "# manifests\n", "initial commit")
+
+		output = &GitHubOutput{
+			Config: GitHubConfig{
+				RepositoryURL: remote,
+				BaseBranch:    "master",
+				ManifestPath:  "{{ .Namespace }}/{{ .Kind }}/{{ .Name }}.yaml",
+				Author:        Author{Name: "manifest-capturer", Email: "capturer@example.com"},
+			},
+			LocalFilePath: filepath.Join(dir, "local"),
+		}
+		snapshot = &Snapshot{
+			Object:   corev1.ObjectReference{Kind: "Deployment", Namespace: "kube-system", Name: "coredns"},
+			Manifest: []byte("spec:\n  replicas: 3\n"),
+			Summary:  []string{"spec.replicas 2→3"},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("pushes a new branch per change in the branch mode", func() {
+		Expect(output.Setup(nil)).To(Succeed())
+
+		_, err := output.Publish("deployment-github-output", snapshot, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(remoteHead(remote, "master").Message).To(Equal("initial commit"))
+
+		names := remoteBranches(remote)
+		Expect(names).To(ContainElement(HavePrefix("manifest-capturer-")))
+		Expect(names).To(ContainElement(HaveSuffix("-deployment-kube-system-coredns")))
+	})
+
+	It("pushes the same branch again when the publication of the change is retried", func() {
+		Expect(output.Setup(nil)).To(Succeed())
+
+		_, err := output.Publish("deployment-github-output", snapshot, nil)
+		Expect(err).NotTo(HaveOccurred())
+		names := remoteBranches(remote)
+		Expect(names).To(HaveLen(2))
+
+		// the retry commits the change again, with the attribution known by then
+		snapshot.Attribution = &Attribution{Manager: "kubectl-edit", Operation: "Update"}
+		_, err = output.Publish("deployment-github-output", snapshot, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(remoteBranches(remote)).To(ConsistOf(names))
+		for _, name := range names {
+			if name != "master" {
+				Expect(remoteHead(remote, name).Message).To(ContainSubstring("kubectl-edit"))
+			}
+		}
+
+		By("pushing another branch for another change")
+		snapshot.Manifest = []byte("spec:\n  replicas: 4\n")
+		_, err = output.Publish("deployment-github-output", snapshot, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(remoteBranches(remote)).To(HaveLen(3))
+	})
+
+	It("pushes a branch removing the manifest on the base branch in the branch mode", func() {
+		Expect(os.MkdirAll(filepath.Join(dir, "human", "kube-system", "Deployment"), 0755)).To(Succeed())
+		commitFile(human, "master", "kube-system/Deployment/coredns.yaml", "spec:\n  replicas: 3\n", "add coredns")
+		Expect(output.Setup(nil)).To(Succeed())
+
+		snapshot.Deleted = true
+		_, err := output.Publish("deployment-github-output", snapshot, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(remoteHead(remote, "master").Message).To(Equal("add coredns"))
+		names := remoteBranches(remote)
+		Expect(names).To(HaveLen(2))
+		for _, name := range names {
+			if name == "master" {
+				continue
+			}
+			head := remoteHead(remote, name)
+			Expect(head.Message).To(HavePrefix("delete manifest of Deployment kube-system/coredns"))
+			_, err = head.File("kube-system/Deployment/coredns.yaml")
+			Expect(err).To(Equal(object.ErrFileNotFound))
+			_, err = head.File("README.md")
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	It("pushes nothing for the deletion of the manifest not on the base branch in the branch mode", func() {
+		Expect(output.Setup(nil)).To(Succeed())
+
+		snapshot.Deleted = true
+		_, err := output.Publish("deployment-github-output", snapshot, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(remoteBranches(remote)).To(Equal([]string{"master"}))
+	})
+
+	It("verifies the repository is reachable on every setup", func() {
+		Expect(output.Setup(nil)).To(Succeed())
+		Expect(output.Setup(nil)).To(Succeed())
+
+		Expect(os.RemoveAll(remote)).To(Succeed())
+		err := output.Setup(nil)
+		Expect(ErrorReason(err, "")).To(Equal(ReasonFetchFailed))
+	})
+
+	It("rejects the manifestPath rendering the same file for the objects targeted by the Capturer", func() {
+		for _, tc := range []struct {
+			manifestPath string
+			spec         CapturerSpec
+			valid        bool
+		}{
+			{"coredns.yaml", CapturerSpec{NamespacedResource: true, ResourceNamespace: "kube-system", ResourceName: "coredns"}, true},
+			{"coredns.yaml", CapturerSpec{NamespacedResource: true, ResourceNamespace: "kube-system", ResourceNamePatterns: []string{"core*"}}, false},
+			{"{{ .Name }}.yaml", CapturerSpec{NamespacedResource: true, ResourceNamespace: "kube-system", ResourceNameRegex: "^core"}, true},
+			{"{{ .Name }}.yaml", CapturerSpec{NamespacedResource: true, Selector: &metav1.LabelSelector{}}, false},
+			{"{{ .Namespace }}.yaml", CapturerSpec{NamespacedResource: true, ResourceName: "coredns"}, true},
+			{"{{ .Kind }}/{{ .Name }}.yaml", CapturerSpec{ResourceKind: "ClusterRole", Selector: &metav1.LabelSelector{}}, true},
+			{"{{ .Namespace }}/{{ .Kind }}/{{ .Name }}.yaml", CapturerSpec{NamespacedResource: true, Selector: &metav1.LabelSelector{}}, true},
+			{"{{ .Unknown }}.yaml", CapturerSpec{NamespacedResource: true, ResourceName: "coredns"}, false},
+		} {
+			output.Config.ManifestPath = tc.manifestPath
+			err := output.validateCapturer(&Capturer{Spec: tc.spec})
+			if tc.valid {
+				Expect(err).NotTo(HaveOccurred(), tc.manifestPath)
+				continue
+			}
+			Expect(ErrorReason(err, "")).To(Equal(ReasonInvalidSpec), tc.manifestPath)
+		}
+	})
+
+	It("rejects the Capturer of Secrets in plaintext without encryption", func() {
+		c := &Capturer{Spec: CapturerSpec{
+			NamespacedResource: true,
+			ResourceKind:       "Secret",
+			ResourceName:       "credentials",
+			SecretPolicy:       SecretPolicyPlaintext,
+		}}
+		Expect(ErrorReason(output.validateCapturer(c), "")).To(Equal(ReasonInvalidSpec))
+
+		c.Spec.SecretPolicy = SecretPolicyHash
+		Expect(output.validateCapturer(c)).To(Succeed())
+
+		c.Spec.SecretPolicy = SecretPolicyPlaintext
+		c.Spec.ResourceAPIVersion = "example.com/v1"
+		Expect(output.validateCapturer(c)).To(Succeed())
+
+		c.Spec.ResourceAPIVersion = ""
+		output.Config.Encryption = &Encryption{Age: []string{"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"}}
+		Expect(output.validateCapturer(c)).To(Succeed())
+	})
+
+	Context("in the direct mode", func() {
+		BeforeEach(func() {
+			output.Config.Mode = GitHubModeDirect
+		})
+
+		It("commits the change onto the base branch", func() {
+			Expect(output.Setup(nil)).To(Succeed())
+
+			_, err := output.Publish("deployment-github-output", snapshot, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			head := remoteHead(remote, "master")
+			Expect(head.Message).To(HavePrefix("Deployment kube-system/coredns: spec.replicas 2→3"))
+			file, err := head.File("kube-system/Deployment/coredns.yaml")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Contents()).To(HaveSuffix("spec:\n  replicas: 3\n"))
+		})
+
+		It("commits the change again onto the base branch moved by someone else", func() {
+			Expect(output.Setup(nil)).To(Succeed())
+			_, err := output.Publish("deployment-github-output", snapshot, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			// the human pushes before the next change is published, which the local clone has never pulled
+			w, err := human.Worktree()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Pull(&git.PullOptions{RemoteName: "origin"})).To(Succeed())
+			commitFile(human, "master", "README.md", "# manifests\n\nmanaged by manifest-capturer\n", "update README")
+			moved := remoteHead(remote, "master")
+
+			// the pull is skipped to simulate the branch moved between the pull and the push
+			local, err := git.PlainOpen(output.LocalFilePath)
+			Expect(err).NotTo(HaveOccurred())
+			snapshot.Manifest = []byte("spec:\n  replicas: 4\n")
+			snapshot.Summary = []string{"spec.replicas 3→4"}
+			mu.Lock()
+			_, err = output.publishDirect(local, "deployment-github-output", snapshot, nil)
+			mu.Unlock()
+			Expect(err).NotTo(HaveOccurred())
+
+			head := remoteHead(remote, "master")
+			Expect(head.Message).To(HavePrefix("Deployment kube-system/coredns: spec.replicas 3→4"))
+			Expect(head.ParentHashes).To(Equal([]plumbing.Hash{moved.Hash}))
+			readme, err := head.File("README.md")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readme.Contents()).To(ContainSubstring("managed by manifest-capturer"))
+		})
+
+		It("deletes the manifest from the base branch", func() {
+			Expect(output.Setup(nil)).To(Succeed())
+			_, err := output.Publish("deployment-github-output", snapshot, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			snapshot.Deleted = true
+			_, err = output.Publish("deployment-github-output", snapshot, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			head := remoteHead(remote, "master")
+			Expect(head.Message).To(HavePrefix("delete manifest of Deployment kube-system/coredns"))
+			_, err = head.File("kube-system/Deployment/coredns.yaml")
+			Expect(err).To(Equal(object.ErrFileNotFound))
+		})
+
+		It("rejects the pull request which needs a branch", func() {
+			output.Config.PullRequest = &PullRequestConfig{}
+
+			err := output.Setup(nil)
+			Expect(ErrorReason(err, "")).To(Equal(ReasonInvalidSpec))
+		})
+	})
+})
@@ -0,0 +1,213 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultGitHubAPIURL is the endpoint of the GitHub REST API of github.com
+const defaultGitHubAPIURL = "https://api.github.com"
+
+// repositoryPath matches the owner and the name of the repository in its URL,
+// e.g. `https://github.com/owner/repo.git` or `git@github.com:owner/repo.git`
+var repositoryPath = regexp.MustCompile(`^(?:[a-z+]+://[^/]+/|[^@/]+@[^:/]+:)([^/]+)/([^/]+?)(?:\.git)?/?$`)
+
+// PullRequestConfig defines the pull request opened for each change against the base branch
+type PullRequestConfig struct {
+	// APIURL is the endpoint of the GitHub REST API, e.g. `https://github.example.com/api/v3` for GitHub Enterprise Server.
+	// It defaults to `https://api.github.com`.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	APIURL string `json:"apiUrl,omitempty"`
+
+	// Labels are added to the pull request.
+	// +kubebuilder:validation:Optional
+
+	Labels []string `json:"labels,omitempty"`
+
+	// Reviewers are the users requested to review the pull request.
+	// +kubebuilder:validation:Optional
+
+	Reviewers []string `json:"reviewers,omitempty"`
+
+	// TeamReviewers are the slugs of the teams requested to review the pull request.
+	// +kubebuilder:validation:Optional
+
+	TeamReviewers []string `json:"teamReviewers,omitempty"`
+
+	// Draft opens the pull request as a draft.
+	// +kubebuilder:validation:Optional
+
+	Draft bool `json:"draft,omitempty"`
+}
+
+// pullRequest is the pull request created by the GitHub REST API
+type pullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+// repository returns the owner and the name of the repository of RepositoryURL
+func (c *GitHubConfig) repository() (string, string, error) {
+	m := repositoryPath.FindStringSubmatch(c.RepositoryURL)
+	if m == nil {
+		return "", "", fmt.Errorf("owner and name of repository are unknown from %s", c.RepositoryURL)
+	}
+	return m[1], m[2], nil
+}
+
+// withholdsDiff reports whether the diff is left out of the pull request, leaving only the summary.
+// The diff of a Secret may hold its values, and the diff of an encrypted manifest its plaintext.
+func (o *GitHubOutput) withholdsDiff(snapshot *Snapshot) bool {
+	return o.Config.Encryption != nil || snapshot.Object.Kind == "Secret"
+}
+
+// openPullRequest opens the pull request of the branch against the base branch, and returns its URL.
+// The pull request already opened for the branch by a previous attempt of the publication is reused.
+// The failures of adding the labels and the reviewers are only logged since the pull request is already open.
+func (o *GitHubOutput) openPullRequest(branch string, name string, snapshot *Snapshot, creds *GitCredentials) (string, error) {
+	pr := o.Config.PullRequest
+	owner, repo, err := o.Config.repository()
+	if err != nil {
+		return "", &OutputError{Reason: ReasonInvalidSpec, Err: err}
+	}
+
+	var opened []pullRequest
+	query := url.Values{"head": {owner + ":" + branch}, "base": {o.Config.BaseBranch}, "state": {"open"}}
+	if err = o.callGitHubAPI("GET", fmt.Sprintf("/repos/%s/%s/pulls?%s", owner, repo, query.Encode()), nil, &opened, creds); err != nil {
+		githubOutputLog.Error(err, "failed to list pull requests", "branch", branch)
+		return "", err
+	}
+	if len(opened) > 0 {
+		return opened[0].HTMLURL, nil
+	}
+
+	title, body := o.commitMessage(snapshot), ""
+	if i := strings.Index(title, "\n"); i >= 0 {
+		title, body = title[:i], strings.TrimSpace(title[i+1:])
+	}
+	if snapshot.Diff != "" && !o.withholdsDiff(snapshot) {
+		body = fmt.Sprintf("%s\n\n```diff\n%s```", body, snapshot.Diff)
+	}
+	body = fmt.Sprintf("%s\n\nThis pull request is opened by manifest-capturer %s.", body, name)
+
+	var created pullRequest
+	if err = o.callGitHubAPI("POST", fmt.Sprintf("/repos/%s/%s/pulls", owner, repo), map[string]interface{}{
+		"title": title,
+		"body":  strings.TrimSpace(body),
+		"head":  branch,
+		"base":  o.Config.BaseBranch,
+		"draft": pr.Draft,
+	}, &created, creds); err != nil {
+		githubOutputLog.Error(err, "failed to open pull request", "branch", branch)
+		return "", err
+	}
+
+	if len(pr.Labels) > 0 {
+		path := fmt.Sprintf("/repos/%s/%s/issues/%d/labels", owner, repo, created.Number)
+		if err = o.callGitHubAPI("POST", path, map[string]interface{}{"labels": pr.Labels}, nil, creds); err != nil {
+			githubOutputLog.Error(err, "failed to add labels to pull request", "url", created.HTMLURL)
+		}
+	}
+
+	if len(pr.Reviewers) > 0 || len(pr.TeamReviewers) > 0 {
+		path := fmt.Sprintf("/repos/%s/%s/pulls/%d/requested_reviewers", owner, repo, created.Number)
+		reviewers := map[string]interface{}{
+			"reviewers":      nonNil(pr.Reviewers),
+			"team_reviewers": nonNil(pr.TeamReviewers),
+		}
+		if err = o.callGitHubAPI("POST", path, reviewers, nil, creds); err != nil {
+			githubOutputLog.Error(err, "failed to request reviewers of pull request", "url", created.HTMLURL)
+		}
+	}
+
+	return created.HTMLURL, nil
+}
+
+// callGitHubAPI sends the request to the GitHub REST API with in as the body unless it is nil,
+// and decodes the response into out unless it is nil
+func (o *GitHubOutput) callGitHubAPI(method string, path string, in interface{}, out interface{}, creds *GitCredentials) error {
+	apiURL := defaultGitHubAPIURL
+	if u := o.Config.PullRequest.APIURL; u != "" {
+		apiURL = u
+	}
+
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(payload)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(apiURL, "/")+path, body)
+	if err != nil {
+		return &OutputError{Reason: ReasonInvalidSpec, Err: err}
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Content-Type", "application/json")
+	if err = o.Config.authorize(req, creds); err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &OutputError{Reason: ReasonPullRequestFailed, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		reason := ReasonPullRequestFailed
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			reason = ReasonAuthFailed
+		}
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return &OutputError{
+			Reason: reason,
+			Err:    fmt.Errorf("%s %s responded %s: %s", method, path, resp.Status, string(body)),
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return &OutputError{Reason: ReasonPullRequestFailed, Err: err}
+	}
+	return nil
+}
+
+// nonNil returns an empty slice for nil, which is encoded as an empty array in JSON
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,250 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// apiRequest is a request received by the stand-in of the GitHub REST API
+type apiRequest struct {
+	Method        string
+	Path          string
+	Query         string
+	Authorization string
+	Body          map[string]interface{}
+}
+
+var _ = Describe("GitHubOutput pull request", func() {
+	var (
+		server   *httptest.Server
+		mux      *http.ServeMux
+		requests []apiRequest
+		output   *GitHubOutput
+		snapshot *Snapshot
+	)
+
+	record := func(status int, response string) http.HandlerFunc {
+		var m sync.Mutex
+		return func(w http.ResponseWriter, req *http.Request) {
+			defer GinkgoRecover()
+
+			body := map[string]interface{}{}
+			if req.ContentLength != 0 {
+				Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+			}
+
+			m.Lock()
+			requests = append(requests, apiRequest{
+				Method:        req.Method,
+				Path:          req.URL.Path,
+				Query:         req.URL.RawQuery,
+				Authorization: req.Header.Get("Authorization"),
+				Body:          body,
+			})
+			m.Unlock()
+
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(response))
+		}
+	}
+
+	// pulls lists the opened pull requests, and records the creation with the response
+	pulls := func(opened string, status int, response string) http.HandlerFunc {
+		list, create := record(http.StatusOK, opened), record(status, response)
+		return func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == "GET" {
+				list(w, req)
+				return
+			}
+			create(w, req)
+		}
+	}
+
+	BeforeEach(func() {
+		requests = nil
+		mux = http.NewServeMux()
+		server = httptest.NewServer(mux)
+
+		output = &GitHubOutput{
+			Config: GitHubConfig{
+				RepositoryURL: "https://github.com/terakoya76/manifests.git",
+				BaseBranch:    "main",
+				ManifestPath:  "{{ .Namespace }}/{{ .Kind }}/{{ .Name }}.yaml",
+				Author:        Author{Name: "manifest-capturer", Email: "capturer@example.com"},
+				PullRequest: &PullRequestConfig{
+					APIURL: server.URL + "/api/v3/",
+				},
+			},
+		}
+		snapshot = &Snapshot{
+			Object:   corev1.ObjectReference{Kind: "Deployment", Namespace: "kube-system", Name: "coredns"},
+			Manifest: []byte("spec:\n  replicas: 3\n"),
+			Diff:     "--- previous\n+++ current\n@@ -1,2 +1,2 @@\n spec:\n-  replicas: 2\n+  replicas: 3\n",
+			Summary:  []string{"spec.replicas 2→3"},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("tells the owner and the name of the repository from its URL", func() {
+		for url, expected := range map[string][]string{
+			"https://github.com/terakoya76/manifests.git": {"terakoya76", "manifests"},
+			"https://github.com/terakoya76/manifests":     {"terakoya76", "manifests"},
+			"git@github.com:terakoya76/manifests.git":     {"terakoya76", "manifests"},
+			"ssh://git@github.com/terakoya76/manifests":   {"terakoya76", "manifests"},
+		} {
+			config := GitHubConfig{RepositoryURL: url}
+			owner, repo, err := config.repository()
+			Expect(err).NotTo(HaveOccurred(), url)
+			Expect([]string{owner, repo}).To(Equal(expected), url)
+		}
+
+		config := GitHubConfig{RepositoryURL: "https://github.com/terakoya76"}
+		_, _, err := config.repository()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("opens the pull request with the title and the body derived from the change", func() {
+		mux.Handle("/api/v3/repos/terakoya76/manifests/pulls", pulls("[]", http.StatusCreated,
+			`{"number": 42, "html_url": "https://github.com/terakoya76/manifests/pull/42"}`))
+
+		output.Config.SecretRef = &corev1.LocalObjectReference{Name: "github-credentials"}
+		creds := &GitCredentials{Token: "secret-token"}
+
+		url, err := output.openPullRequest("manifest-capturer-20210301123456-deployment-kube-system-coredns", "deployment-github-output", snapshot, creds)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url).To(Equal("https://github.com/terakoya76/manifests/pull/42"))
+
+		Expect(requests).To(HaveLen(2))
+		Expect(requests[0].Method).To(Equal("GET"))
+		Expect(requests[0].Query).To(Equal("base=main&head=terakoya76%3Amanifest-capturer-20210301123456-deployment-kube-system-coredns&state=open"))
+		pr := requests[1]
+		Expect(pr.Method).To(Equal("POST"))
+		Expect(pr.Authorization).To(Equal("token secret-token"))
+		Expect(pr.Body).To(HaveKeyWithValue("title", "Deployment kube-system/coredns: spec.replicas 2→3"))
+		Expect(pr.Body).To(HaveKeyWithValue("head", "manifest-capturer-20210301123456-deployment-kube-system-coredns"))
+		Expect(pr.Body).To(HaveKeyWithValue("base", "main"))
+		Expect(pr.Body).To(HaveKeyWithValue("draft", false))
+		Expect(pr.Body["body"]).To(ContainSubstring("- spec.replicas 2→3"))
+		Expect(pr.Body["body"]).To(ContainSubstring("Deployment kube-system/coredns: 1 insertions(+), 1 deletions(-)"))
+		Expect(pr.Body["body"]).To(ContainSubstring("```diff\n--- previous"))
+		Expect(pr.Body["body"]).To(ContainSubstring("manifest-capturer deployment-github-output"))
+	})
+
+	It("leaves the diff of a Secret or an encrypted manifest out of the body", func() {
+		mux.Handle("/api/v3/repos/terakoya76/manifests/pulls", pulls("[]", http.StatusCreated,
+			`{"number": 42, "html_url": "https://github.com/terakoya76/manifests/pull/42"}`))
+
+		secret := &Snapshot{
+			Object:   corev1.ObjectReference{Kind: "Secret", Namespace: "default", Name: "db"},
+			Manifest: []byte("data:\n  password: bmV3\n"),
+			Diff:     "--- previous\n+++ current\n@@ -1,2 +1,2 @@\n data:\n-  password: b2xk\n+  password: bmV3\n",
+			Summary:  []string{"Secret key password modified (+1/-1 lines)"},
+		}
+		_, err := output.openPullRequest("manifest-capturer-branch", "secret-github-output", secret, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		output.Config.Encryption = &Encryption{}
+		_, err = output.openPullRequest("manifest-capturer-branch", "deployment-github-output", snapshot, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(requests).To(HaveLen(4))
+		Expect(requests[1].Body["body"]).To(ContainSubstring("- Secret key password modified (+1/-1 lines)"))
+		Expect(requests[1].Body["body"]).NotTo(ContainSubstring("```diff"))
+		Expect(requests[1].Body["body"]).NotTo(ContainSubstring("bmV3"))
+		Expect(requests[3].Body["body"]).To(ContainSubstring("- spec.replicas 2→3"))
+		Expect(requests[3].Body["body"]).NotTo(ContainSubstring("```diff"))
+	})
+
+	It("adds the labels and requests the reviewers of a draft pull request", func() {
+		mux.Handle("/api/v3/repos/terakoya76/manifests/pulls", pulls("[]", http.StatusCreated,
+			`{"number": 42, "html_url": "https://github.com/terakoya76/manifests/pull/42"}`))
+		mux.Handle("/api/v3/repos/terakoya76/manifests/issues/42/labels", record(http.StatusOK, `[]`))
+		mux.Handle("/api/v3/repos/terakoya76/manifests/pulls/42/requested_reviewers", record(http.StatusCreated, `{}`))
+
+		output.Config.PullRequest.Labels = []string{"manifest-capturer", "kube-system"}
+		output.Config.PullRequest.Reviewers = []string{"alice"}
+		output.Config.PullRequest.TeamReviewers = []string{"sre"}
+		output.Config.PullRequest.Draft = true
+
+		_, err := output.openPullRequest("manifest-capturer-branch", "deployment-github-output", snapshot, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(requests).To(HaveLen(4))
+		Expect(requests[1].Body).To(HaveKeyWithValue("draft", true))
+		Expect(requests[2].Path).To(Equal("/api/v3/repos/terakoya76/manifests/issues/42/labels"))
+		Expect(requests[2].Body).To(HaveKeyWithValue("labels", []interface{}{"manifest-capturer", "kube-system"}))
+		Expect(requests[3].Path).To(Equal("/api/v3/repos/terakoya76/manifests/pulls/42/requested_reviewers"))
+		Expect(requests[3].Body).To(HaveKeyWithValue("reviewers", []interface{}{"alice"}))
+		Expect(requests[3].Body).To(HaveKeyWithValue("team_reviewers", []interface{}{"sre"}))
+	})
+
+	It("keeps the pull request open even if the reviewers cannot be requested", func() {
+		mux.Handle("/api/v3/repos/terakoya76/manifests/pulls", pulls("[]", http.StatusCreated,
+			`{"number": 42, "html_url": "https://github.com/terakoya76/manifests/pull/42"}`))
+		mux.Handle("/api/v3/repos/terakoya76/manifests/pulls/42/requested_reviewers", record(http.StatusUnprocessableEntity,
+			`{"message": "Reviews may only be requested from collaborators."}`))
+
+		output.Config.PullRequest.Reviewers = []string{"mallory"}
+
+		url, err := output.openPullRequest("manifest-capturer-branch", "deployment-github-output", snapshot, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url).To(Equal("https://github.com/terakoya76/manifests/pull/42"))
+	})
+
+	It("reports the failure to open the pull request with its reason", func() {
+		mux.Handle("/api/v3/repos/terakoya76/manifests/pulls", pulls("[]", http.StatusUnprocessableEntity,
+			`{"message": "Validation Failed"}`))
+
+		_, err := output.openPullRequest("manifest-capturer-branch", "deployment-github-output", snapshot, nil)
+		Expect(err).To(HaveOccurred())
+		Expect(ErrorReason(err, "")).To(Equal(ReasonPullRequestFailed))
+		Expect(err.Error()).To(ContainSubstring("Validation Failed"))
+	})
+
+	It("reuses the pull request opened for the branch by a previous attempt", func() {
+		mux.Handle("/api/v3/repos/terakoya76/manifests/pulls", pulls(
+			`[{"number": 41, "html_url": "https://github.com/terakoya76/manifests/pull/41"}]`,
+			http.StatusCreated, `{"number": 42, "html_url": "https://github.com/terakoya76/manifests/pull/42"}`))
+		output.Config.PullRequest.Labels = []string{"manifest-capturer"}
+
+		url, err := output.openPullRequest("manifest-capturer-branch", "deployment-github-output", snapshot, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url).To(Equal("https://github.com/terakoya76/manifests/pull/41"))
+
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Method).To(Equal("GET"))
+	})
+
+	It("reports the rejected token as an authentication failure", func() {
+		mux.Handle("/api/v3/repos/terakoya76/manifests/pulls", record(http.StatusUnauthorized,
+			`{"message": "Bad credentials"}`))
+
+		_, err := output.openPullRequest("manifest-capturer-branch", "deployment-github-output", snapshot, nil)
+		Expect(ErrorReason(err, "")).To(Equal(ReasonAuthFailed))
+	})
+})
@@ -0,0 +1,147 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ManifestSnapshotSpec defines the manifest of an object captured by a Capturer
+type ManifestSnapshotSpec struct {
+	// Capturer is the name of the Capturer which captured the object
+	Capturer string `json:"capturer"`
+
+	// Object is the reference to the captured object, including its resourceVersion and UID
+	Object corev1.ObjectReference `json:"object"`
+
+	// CapturedAt is the time of the capture
+	CapturedAt metav1.Time `json:"capturedAt"`
+
+	// Sequence orders the snapshots of the object, increasing by one per snapshot.
+	// It tells the order of the snapshots taken within a second, which CapturedAt cannot.
+	Sequence int64 `json:"sequence"`
+
+	// Deleted reports the object was deleted
+	// +optional
+	Deleted bool `json:"deleted,omitempty"`
+
+	// Manifest is the normalized manifest of the object in YAML.
+	// The values of a Secret captured in plaintext are kept hashed.
+	// +optional
+	Manifest string `json:"manifest,omitempty"`
+
+	// Hash is the SHA-256 hash of the manifest published to the Outputs
+	// +optional
+	Hash string `json:"hash,omitempty"`
+
+	// Diff is the unified diff from the previous capture. It is omitted for a Secret captured in plaintext.
+	// +optional
+	Diff string `json:"diff,omitempty"`
+
+	// Summary is the list of the changes from the previous capture
+	// +optional
+	Summary []string `json:"summary,omitempty"`
+
+	// Attribution identifies who made the change
+	// +optional
+	Attribution *SnapshotAttribution `json:"attribution,omitempty"`
+}
+
+// SnapshotAttribution is the Attribution kept in a ManifestSnapshot
+type SnapshotAttribution struct {
+	// Manager is the name of the field manager, e.g. `kubectl-edit`
+	// +optional
+	Manager string `json:"manager,omitempty"`
+
+	// Operation is the operation of the change, e.g. `Update`
+	// +optional
+	Operation string `json:"operation,omitempty"`
+
+	// Time is when the change was made
+	// +optional
+	Time *metav1.Time `json:"time,omitempty"`
+
+	// User is the user who requested the change
+	// +optional
+	User string `json:"user,omitempty"`
+
+	// Groups are the groups of the user
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+
+	// UserAgent is the user agent of the request
+	// +optional
+	UserAgent string `json:"userAgent,omitempty"`
+
+	// SourceIPs are the source IPs of the request
+	// +optional
+	SourceIPs []string `json:"sourceIPs,omitempty"`
+}
+
+// NewSnapshotAttribution converts the Attribution to be kept in a ManifestSnapshot.
+// It returns nil if a is nil.
+func NewSnapshotAttribution(a *Attribution) *SnapshotAttribution {
+	if a == nil {
+		return nil
+	}
+
+	sa := &SnapshotAttribution{
+		Manager:   a.Manager,
+		Operation: a.Operation,
+		User:      a.User,
+		Groups:    a.Groups,
+		UserAgent: a.UserAgent,
+		SourceIPs: a.SourceIPs,
+	}
+	if !a.Time.IsZero() {
+		t := metav1.NewTime(a.Time)
+		sa.Time = &t
+	}
+	return sa
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Capturer",type=string,JSONPath=`.spec.capturer`
+// +kubebuilder:printcolumn:name="Kind",type=string,JSONPath=`.spec.object.kind`
+// +kubebuilder:printcolumn:name="Object",type=string,JSONPath=`.spec.object.name`
+// +kubebuilder:printcolumn:name="Sequence",type=integer,JSONPath=`.spec.sequence`
+// +kubebuilder:printcolumn:name="Deleted",type=boolean,JSONPath=`.spec.deleted`
+// +kubebuilder:printcolumn:name="Hash",type=string,JSONPath=`.spec.hash`,priority=1
+// +kubebuilder:printcolumn:name="Captured At",type=date,JSONPath=`.spec.capturedAt`
+
+// ManifestSnapshot is the Schema for the manifestsnapshots API.
+// It keeps the history of the manifests captured by a Capturer in the cluster.
+type ManifestSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ManifestSnapshotSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ManifestSnapshotList contains a list of ManifestSnapshot
+type ManifestSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ManifestSnapshot `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ManifestSnapshot{}, &ManifestSnapshotList{})
+}
@@ -16,10 +16,216 @@ limitations under the License.
 
 package v1alpha1
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Snapshot is a manifest captured from a single object
+// +kubebuilder:object:generate=false
+type Snapshot struct {
+	// Object identifies the captured object
+	Object corev1.ObjectReference
+
+	// Manifest is the captured manifest in YAML.
+	// For a deleted object, it is the last known manifest.
+	Manifest []byte
+
+	// Deleted reports the object has been deleted
+	Deleted bool
+
+	// Diff is the unified diff from the previously captured manifest.
+	// It is empty when the object is captured for the first time or deleted.
+	Diff string
+
+	// Summary describes the changes from the previously captured manifest field by field,
+	// e.g. `spec.replicas 2→3`
+	Summary []string
+
+	// Attribution identifies who made the change. It is nil if unknown.
+	Attribution *Attribution
+
+	// Restorability is the result of the dry-run apply of the manifest. It is nil if not validated.
+	Restorability *Restorability
+}
+
+// Restorability tells whether the captured manifest can be applied back to the cluster
+// +kubebuilder:object:generate=false
+type Restorability struct {
+	// Restorable reports the manifest passed the dry-run apply
+	Restorable bool
+
+	// Unknown reports the dry-run apply was forbidden, e.g. since the kind is not granted to be restored
+	Unknown bool
+
+	// Message is why the manifest would be rejected
+	Message string
+}
+
+// Attribution identifies the field manager which made the latest change of the object
+// +kubebuilder:object:generate=false
+type Attribution struct {
+	// Manager is the name of the field manager, e.g. `kubectl-edit`
+	Manager string
+
+	// Operation is the operation of the field manager, either `Apply` or `Update`
+	Operation string
+
+	// Time is when the change was made
+	Time time.Time
+
+	// User is the user who requested the change, known from the audit events
+	User string
+
+	// Groups are the groups of the user
+	Groups []string
+
+	// UserAgent is the user agent of the request
+	UserAgent string
+
+	// SourceIPs are the source IPs of the request
+	SourceIPs []string
+}
+
+// String returns the field manager with its operation, e.g. `kubectl-edit (Update)`.
+// Only the operation is returned if the field manager is unknown.
+func (a *Attribution) String() string {
+	if a.Manager == "" {
+		return a.Operation
+	}
+	return fmt.Sprintf("%s (%s)", a.Manager, a.Operation)
+}
+
+// Requester returns the user who requested the change with the groups, the source IPs and the user agent,
+// e.g. `alice (system:authenticated) from 10.0.0.1 with kubectl/v1.20.0`. It is empty if the user is unknown.
+func (a *Attribution) Requester() string {
+	if a.User == "" {
+		return ""
+	}
+
+	s := a.User
+	if len(a.Groups) > 0 {
+		s = fmt.Sprintf("%s (%s)", s, strings.Join(a.Groups, ", "))
+	}
+	if len(a.SourceIPs) > 0 {
+		s = fmt.Sprintf("%s from %s", s, strings.Join(a.SourceIPs, ", "))
+	}
+	if a.UserAgent != "" {
+		s = fmt.Sprintf("%s with %s", s, a.UserAgent)
+	}
+	return s
+}
+
+// Headline summarizes the changes in a line, e.g. `Deployment kube-system/coredns: spec.replicas 2→3 and 1 more change`.
+// It is empty if there is no summary.
+func (s *Snapshot) Headline() string {
+	switch len(s.Summary) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%s: %s", s, s.Summary[0])
+	case 2:
+		return fmt.Sprintf("%s: %s and 1 more change", s, s.Summary[0])
+	}
+	return fmt.Sprintf("%s: %s and %d more changes", s, s.Summary[0], len(s.Summary)-1)
+}
+
+// summaryList formats Summary as a list with the bullet, followed by an empty line
+func summaryList(s *Snapshot, bullet string) string {
+	if len(s.Summary) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, change := range s.Summary {
+		b.WriteString(bullet + change + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// DiffStat returns the number of the added and removed lines in Diff
+func (s *Snapshot) DiffStat() (added, removed int) {
+	for _, line := range strings.Split(s.Diff, "\n") {
+		switch {
+		case line == "+++ current", line == "--- previous":
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// String returns the identity of the captured object, e.g. `ConfigMap kube-system/coredns`
+func (s *Snapshot) String() string {
+	if s.Object.Namespace == "" {
+		return fmt.Sprintf("%s %s", s.Object.Kind, s.Object.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", s.Object.Kind, s.Object.Namespace, s.Object.Name)
+}
+
+// Reasons of the failures of Output
+const (
+	ReasonInvalidSpec        = "InvalidSpec"
+	ReasonSetupFailed        = "SetupFailed"
+	ReasonCloneFailed        = "CloneFailed"
+	ReasonAuthFailed         = "AuthFailed"
+	ReasonPullFailed         = "PullFailed"
+	ReasonPushFailed         = "PushFailed"
+	ReasonEncryptFailed      = "EncryptFailed"
+	ReasonWebhookUnreachable = "WebhookUnreachable"
+	ReasonWebhookRejected    = "WebhookRejected"
+	ReasonPublishFailed      = "PublishFailed"
+	ReasonFetchFailed        = "FetchFailed"
+	ReasonSnapshotNotFound   = "SnapshotNotFound"
+	ReasonPullRequestFailed  = "PullRequestFailed"
+	ReasonInvalidCredentials = "InvalidCredentials"
+	ReasonOutOfScope         = "OutOfScope"
+)
+
+// OutputError is an error of Output with the reason of the failure
+// +kubebuilder:object:generate=false
+type OutputError struct {
+	Reason string
+	Err    error
+}
+
+func (e *OutputError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorReason returns the reason of the Output failure, or fallback if it is unknown
+func ErrorReason(err error, fallback string) string {
+	var oerr *OutputError
+	if errors.As(err, &oerr) {
+		return oerr.Reason
+	}
+	return fallback
+}
+
+// Publication is the result of a successful publication
+// +kubebuilder:object:generate=false
+type Publication struct {
+	// URL is where the snapshot is published for review, e.g. the pull request. It is empty if none.
+	URL string
+}
+
 // publish provides I/F for publishing output
+// The credentials resolved from the secretRef of the Output are passed by the controllers,
+// which are nil if the Output refers none.
 type publisher interface {
-	Setup() error
-	Publish(name string, manifest []byte) error
+	Setup(creds *GitCredentials) error
+	Publish(name string, snapshot *Snapshot, creds *GitCredentials) (*Publication, error)
 }
 
 // GetPublisher returns Publisher along w/ its Spec
@@ -32,3 +238,28 @@ func (o *Output) GetPublisher() publisher {
 	}
 	return nil
 }
+
+// Encrypts reports whether the Output encrypts the manifests of Secrets before they leave the cluster
+func (o *Output) Encrypts() bool {
+	return o.Spec.GitHub != nil && o.Spec.GitHub.Config.Encryption != nil
+}
+
+// ValidateCapturer checks the Output keeps the objects targeted by the Capturer apart
+func (o *Output) ValidateCapturer(c *Capturer) error {
+	if o.Spec.GitHub != nil {
+		return o.Spec.GitHub.validateCapturer(c)
+	}
+	return nil
+}
+
+type fetcher interface {
+	Fetch(ref SnapshotReference, object corev1.ObjectReference, creds *GitCredentials) ([]byte, string, error)
+}
+
+// GetFetcher returns the Output keeping the snapshots which can be fetched back, or nil
+func (o *Output) GetFetcher() fetcher {
+	if o.Spec.GitHub != nil {
+		return o.Spec.GitHub
+	}
+	return nil
+}
@@ -29,11 +29,52 @@ type OutputSpec struct {
 	Slack  *SlackOutput  `json:"slack,omitempty"`
 }
 
+// Condition types of Output
+const (
+	// OutputReady means the Output is set up and the last publication succeeded
+	OutputReady = "Ready"
+)
+
 // OutputStatus defines the observed state of Output
 type OutputStatus struct {
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// LastPublishedAt is the time of the last successful publication.
+	// +optional
+	LastPublishedAt *metav1.Time `json:"lastPublishedAt,omitempty"`
+
+	// LastPublicationURL is the URL of the last publication for review, e.g. the pull request.
+	// +optional
+	LastPublicationURL string `json:"lastPublicationUrl,omitempty"`
+
+	// LastError is the error of the last failed setup or publication.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// LastErrorAt is the time of LastError.
+	// +optional
+	LastErrorAt *metav1.Time `json:"lastErrorAt,omitempty"`
+
+	// PublishCount is the number of successful publications.
+	// +optional
+	PublishCount int64 `json:"publishCount,omitempty"`
+
+	// FailureCount is the number of failed publications.
+	// +optional
+	FailureCount int64 `json:"failureCount,omitempty"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+// +kubebuilder:printcolumn:name="Last Published",type=date,JSONPath=`.status.lastPublishedAt`
+// +kubebuilder:printcolumn:name="Published",type=integer,JSONPath=`.status.publishCount`,priority=1
+// +kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failureCount`,priority=1
+// +kubebuilder:printcolumn:name="Last URL",type=string,JSONPath=`.status.lastPublicationUrl`,priority=1
+// +kubebuilder:printcolumn:name="Last Error",type=string,JSONPath=`.status.lastError`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Output is the Schema for the outputs API
 type Output struct {
@@ -0,0 +1,141 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestoreSpec defines the desired state of Restore
+type RestoreSpec struct {
+	// Capturer is the name of the Capturer which captured the object, in the namespace of the Restore
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Format:=string
+
+	Capturer string `json:"capturer"`
+
+	// Output is the name of the Output keeping the snapshot, in the namespace of the Restore.
+	// Only GitHub outputs keep snapshots which can be restored.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Format:=string
+
+	Output string `json:"output"`
+
+	// Snapshot identifies the snapshot to be restored. The latest one on the base branch is restored if omitted.
+	// +kubebuilder:validation:Optional
+
+	Snapshot SnapshotReference `json:"snapshot,omitempty"`
+
+	// ObjectNamespace is the namespace of the object to be restored.
+	// It defaults to the resourceNamespace of the Capturer.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	ObjectNamespace string `json:"objectNamespace,omitempty"`
+
+	// ObjectName is the name of the object to be restored.
+	// It defaults to the resourceName of the Capturer.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	ObjectName string `json:"objectName,omitempty"`
+
+	// Force takes the ownership of the fields conflicting with the other field managers
+	// +kubebuilder:validation:Optional
+
+	Force bool `json:"force,omitempty"`
+}
+
+// SnapshotReference identifies a snapshot kept in an Output.
+// Commit takes precedence over Branch, and Time picks the last snapshot before it on the branch.
+type SnapshotReference struct {
+	// Commit is the revision of the commit, e.g. a hash or a tag
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	Commit string `json:"commit,omitempty"`
+
+	// Branch is the branch having the snapshot. It defaults to the base branch of the Output.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Format:=string
+
+	Branch string `json:"branch,omitempty"`
+
+	// Time picks the last snapshot committed at or before it
+	// +kubebuilder:validation:Optional
+
+	Time *metav1.Time `json:"time,omitempty"`
+}
+
+// Condition types of Restore
+const (
+	// RestoreSucceeded means the snapshot was applied to the cluster
+	RestoreSucceeded = "Succeeded"
+)
+
+// RestoreStatus defines the observed state of Restore
+type RestoreStatus struct {
+	// ObservedGeneration is the generation of the Restore spec which has been processed.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// Commit is the commit of the restored snapshot.
+	// +optional
+	Commit string `json:"commit,omitempty"`
+
+	// RestoredAt is the time the snapshot was applied.
+	// +optional
+	RestoredAt *metav1.Time `json:"restoredAt,omitempty"`
+
+	// Conflicts are the fields managed by the other field managers, which prevented the restore.
+	// +optional
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Capturer",type=string,JSONPath=`.spec.capturer`
+// +kubebuilder:printcolumn:name="Succeeded",type=string,JSONPath=`.status.conditions[?(@.type=="Succeeded")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Succeeded")].reason`
+// +kubebuilder:printcolumn:name="Commit",type=string,JSONPath=`.status.commit`,priority=1
+// +kubebuilder:printcolumn:name="Restored At",type=date,JSONPath=`.status.restoredAt`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Restore is the Schema for the restores API
+type Restore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RestoreSpec   `json:"spec,omitempty"`
+	Status RestoreStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// RestoreList contains a list of Restore
+type RestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Restore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Restore{}, &RestoreList{})
+}
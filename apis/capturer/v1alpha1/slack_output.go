@@ -20,8 +20,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"strings"
+	"time"
 )
 
 // SlackOutput defines the spec for integrating with GitHub
@@ -32,33 +34,102 @@ type SlackOutput struct {
 	WebhookURL string `json:"webhookUrl"`
 }
 
-func (o *SlackOutput) Setup() error {
+// Setup verifies the webhook is reachable by posting an empty payload,
+// which Slack rejects with 400 Bad Request without posting any message.
+func (o *SlackOutput) Setup(_ *GitCredentials) error {
+	resp, err := o.post([]byte("{}"))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+		return &OutputError{
+			Reason: ReasonWebhookRejected,
+			Err:    fmt.Errorf("webhook responded %s", resp.Status),
+		}
+	}
+
 	return nil
 }
 
-func (o *SlackOutput) Publish(name string, manifest []byte) (err error) {
-	url := o.WebhookURL
-
+// Publish posts the snapshot to the webhook, which needs no credentials
+func (o *SlackOutput) Publish(name string, snapshot *Snapshot, _ *GitCredentials) (*Publication, error) {
 	content := fmt.Sprintf(
-		"A capture is reported by manifest-capturer %s\n\n```%s```",
+		"A capture of %s is reported by manifest-capturer %s\n\n```%s```",
+		snapshot,
 		name,
-		string(manifest),
+		string(snapshot.Manifest),
 	)
+	if snapshot.Diff != "" {
+		added, removed := snapshot.DiffStat()
+		content = fmt.Sprintf(
+			"A change of %s (+%d/-%d lines) is reported by manifest-capturer %s\n\n%s```%s```",
+			snapshot,
+			added,
+			removed,
+			name,
+			summaryList(snapshot, "• "),
+			snapshot.Diff,
+		)
+		if headline := snapshot.Headline(); headline != "" {
+			content = fmt.Sprintf("*%s*\n%s", headline, content)
+		}
+	}
+	if snapshot.Deleted {
+		content = fmt.Sprintf(
+			"A deletion of %s is reported by manifest-capturer %s\n\nThe last known manifest is\n```%s```",
+			snapshot,
+			name,
+			string(snapshot.Manifest),
+		)
+	}
 
-	var jsonStr = []byte(fmt.Sprintf(`{"text":"%s"}`, escapeString(content)))
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(json.RawMessage(jsonStr)))
-	req.Header.Set("Content-Type", "application/json")
+	if rs := snapshot.Restorability; rs != nil && !rs.Restorable && !rs.Unknown {
+		content = fmt.Sprintf("%s\n:warning: This snapshot cannot be restored: %s", content, rs.Message)
+	}
+	if a := snapshot.Attribution; a != nil {
+		content = fmt.Sprintf("%s\nChanged by %s at %s", content, a, a.Time.UTC().Format(time.RFC3339))
+		if requester := a.Requester(); requester != "" {
+			content = fmt.Sprintf("%s\nRequested by %s", content, requester)
+		}
+	}
 
-	client := http.Client{}
-	resp, err := client.Do(req)
+	jsonStr, err := json.Marshal(map[string]string{"text": content})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	resp, err := o.post(jsonStr)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	return nil
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &OutputError{
+			Reason: ReasonWebhookRejected,
+			Err:    fmt.Errorf("webhook responded %s: %s", resp.Status, string(body)),
+		}
+	}
+
+	return &Publication{}, nil
 }
 
-func escapeString(str string) string {
-	return strings.Replace(str, `"`, `\"`, -1)
+func (o *SlackOutput) post(payload []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", o.WebhookURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, &OutputError{Reason: ReasonInvalidSpec, Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &OutputError{Reason: ReasonWebhookUnreachable, Err: err}
+	}
+
+	return resp, nil
 }
@@ -0,0 +1,70 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+// attributeChange identifies the field manager which made the latest change of the object
+// from its managedFields. The entries only managing status are ignored since status is not captured.
+// It returns nil if no entry has its time.
+func attributeChange(obj metav1.Object) *capturerv1alpha1.Attribution {
+	var latest *metav1.ManagedFieldsEntry
+	entries := obj.GetManagedFields()
+	for i := range entries {
+		e := &entries[i]
+		if e.Time == nil || onlyStatus(e) {
+			continue
+		}
+		// the later entry wins on the same time, whose precision is a second
+		if latest == nil || !e.Time.Before(latest.Time) {
+			latest = e
+		}
+	}
+
+	if latest == nil {
+		return nil
+	}
+	return &capturerv1alpha1.Attribution{
+		Manager:   latest.Manager,
+		Operation: string(latest.Operation),
+		Time:      latest.Time.Time,
+	}
+}
+
+// onlyStatus reports whether the entry manages no field other than status
+func onlyStatus(e *metav1.ManagedFieldsEntry) bool {
+	if e.FieldsV1 == nil {
+		return false
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(e.FieldsV1.Raw, &fields); err != nil {
+		return false
+	}
+	for f := range fields {
+		if f != "f:status" {
+			return false
+		}
+	}
+	return len(fields) > 0
+}
@@ -0,0 +1,95 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("attributeChange", func() {
+	base := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// managedFields returns the entry of the manager updating the fields at the minute, or without the time if minute is negative
+	managedFields := func(manager string, minute int, fields string) metav1.ManagedFieldsEntry {
+		e := metav1.ManagedFieldsEntry{
+			Manager:   manager,
+			Operation: metav1.ManagedFieldsOperationUpdate,
+		}
+		if minute >= 0 {
+			e.Time = &metav1.Time{Time: base.Add(time.Duration(minute) * time.Minute)}
+		}
+		if fields != "" {
+			e.FieldsType = "FieldsV1"
+			e.FieldsV1 = &metav1.FieldsV1{Raw: []byte(fields)}
+		}
+		return e
+	}
+
+	DescribeTable("attributes the change to the manager of the latest entry",
+		func(entries []metav1.ManagedFieldsEntry, expected *capturerv1alpha1.Attribution) {
+			obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{ManagedFields: entries}}
+			Expect(attributeChange(obj)).To(Equal(expected))
+		},
+		Entry("the latest of the entries",
+			[]metav1.ManagedFieldsEntry{
+				managedFields("kubectl", 5, `{"f:data":{}}`),
+				managedFields("helm", 10, `{"f:data":{}}`),
+				managedFields("kube-controller-manager", 1, `{"f:metadata":{}}`),
+			},
+			&capturerv1alpha1.Attribution{Manager: "helm", Operation: "Update", Time: base.Add(10 * time.Minute)}),
+		Entry("the later entry at the same time",
+			[]metav1.ManagedFieldsEntry{
+				managedFields("kubectl", 10, `{"f:data":{}}`),
+				managedFields("helm", 10, `{"f:data":{}}`),
+			},
+			&capturerv1alpha1.Attribution{Manager: "helm", Operation: "Update", Time: base.Add(10 * time.Minute)}),
+		Entry("the latest entry not only managing status",
+			[]metav1.ManagedFieldsEntry{
+				managedFields("kubectl", 5, `{"f:spec":{}}`),
+				managedFields("kube-controller-manager", 10, `{"f:status":{"f:replicas":{}}}`),
+				managedFields("kube-scheduler", 15, `{"f:metadata":{},"f:status":{}}`),
+			},
+			&capturerv1alpha1.Attribution{Manager: "kube-scheduler", Operation: "Update", Time: base.Add(15 * time.Minute)}),
+		Entry("the latest entry with its time",
+			[]metav1.ManagedFieldsEntry{
+				managedFields("kubectl", 5, `{"f:data":{}}`),
+				managedFields("helm", -1, `{"f:data":{}}`),
+			},
+			&capturerv1alpha1.Attribution{Manager: "kubectl", Operation: "Update", Time: base.Add(5 * time.Minute)}),
+		Entry("the latest entry without the fields",
+			[]metav1.ManagedFieldsEntry{
+				managedFields("kubectl", 5, `{"f:data":{}}`),
+				managedFields("helm", 10, ""),
+			},
+			&capturerv1alpha1.Attribution{Manager: "helm", Operation: "Update", Time: base.Add(10 * time.Minute)}),
+		Entry("nobody without the entries", nil, nil),
+		Entry("nobody without the entries with their time",
+			[]metav1.ManagedFieldsEntry{
+				managedFields("kubectl", -1, `{"f:data":{}}`),
+				managedFields("kube-controller-manager", 10, `{"f:status":{}}`),
+			},
+			nil),
+	)
+})
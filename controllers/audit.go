@@ -0,0 +1,470 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+const (
+	// auditPath is the path receiving the audit events
+	auditPath = "/audit"
+
+	// auditRetention is how long the received audit events are kept for the correlation
+	auditRetention = 10 * time.Minute
+)
+
+// auditEventList is the subset of audit.k8s.io/v1 EventList sent by the audit webhook backend
+type auditEventList struct {
+	Items []auditEvent `json:"items"`
+}
+
+// auditEvent is the subset of audit.k8s.io/v1 Event
+type auditEvent struct {
+	AuditID          string          `json:"auditID"`
+	Stage            string          `json:"stage"`
+	Verb             string          `json:"verb"`
+	User             auditUser       `json:"user"`
+	ImpersonatedUser *auditUser      `json:"impersonatedUser,omitempty"`
+	SourceIPs        []string        `json:"sourceIPs,omitempty"`
+	UserAgent        string          `json:"userAgent,omitempty"`
+	ObjectRef        *auditObjectRef `json:"objectRef,omitempty"`
+	ResponseStatus   *metav1.Status  `json:"responseStatus,omitempty"`
+	ResponseObject   json.RawMessage `json:"responseObject,omitempty"`
+	StageTimestamp   metav1.Time     `json:"stageTimestamp"`
+}
+
+type auditUser struct {
+	Username string   `json:"username,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+type auditObjectRef struct {
+	Resource        string    `json:"resource,omitempty"`
+	Namespace       string    `json:"namespace,omitempty"`
+	Name            string    `json:"name,omitempty"`
+	UID             types.UID `json:"uid,omitempty"`
+	APIGroup        string    `json:"apiGroup,omitempty"`
+	APIVersion      string    `json:"apiVersion,omitempty"`
+	ResourceVersion string    `json:"resourceVersion,omitempty"`
+	Subresource     string    `json:"subresource,omitempty"`
+}
+
+// auditEntry is a received request changing an object
+type auditEntry struct {
+	event           *auditEvent
+	uid             types.UID
+	resourceVersion string
+	receivedAt      time.Time
+}
+
+// AuditReceiver receives the audit events from the audit webhook backend of kube-apiserver,
+// and tells who requested the captured changes.
+// The endpoint is served over TLS, and only accepts the requests authenticated
+// by a client certificate signed by ClientCAFile or by Token.
+type AuditReceiver struct {
+	Log logr.Logger
+
+	// BindAddress is the address the endpoint binds to
+	BindAddress string
+
+	// CertFile and KeyFile are the serving certificate and key of the endpoint
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is the CA bundle verifying the client certificates,
+	// i.e. the client-certificate in the kubeconfig of the audit webhook backend
+	ClientCAFile string
+
+	// Token is the bearer token accepted from the clients,
+	// i.e. the token in the kubeconfig of the audit webhook backend
+	Token string
+
+	// Mapper maps the kinds of the captured objects to the resources in the audit events
+	Mapper meta.RESTMapper
+
+	// Wait is how long a capture is delayed for the audit event of the change,
+	// since the audit webhook backend sends the events in batches
+	Wait time.Duration
+
+	mu sync.Mutex
+	// byVersion indexes the entries by the uid and resourceVersion of the response object
+	byVersion map[string]*auditEntry
+	// byObject indexes the entries without the resourceVersion and of the deletions by the requested object,
+	// the oldest first
+	byObject map[string][]*auditEntry
+	// matched is the entries matched to the captured changes, which are kept for the retries of the captures
+	matched map[string]*auditEntry
+	// waiting is when the captures started waiting for the events, by the captured change
+	waiting map[string]time.Time
+}
+
+// ServeHTTP receives an EventList
+func (a *AuditReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !a.authenticate(req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var events auditEventList
+	if err := json.NewDecoder(req.Body).Decode(&events); err != nil {
+		a.Log.Error(err, "failed to decode audit events")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.record(events.Items)
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticate reports whether the request has a verified client certificate or the bearer token
+func (a *AuditReceiver) authenticate(req *http.Request) bool {
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	if a.Token == "" {
+		return false
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+// tlsConfig returns the TLS config verifying the client certificates if ClientCAFile is set.
+// The certificates are optional so that the clients can authenticate by the token instead.
+func (a *AuditReceiver) tlsConfig() (*tls.Config, error) {
+	if a.ClientCAFile == "" && a.Token == "" {
+		return nil, errors.New("either a client CA or a token is required to authenticate the audit webhook backend")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if a.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(a.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", a.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// Start serves the endpoint over TLS until stop is closed
+func (a *AuditReceiver) Start(stop <-chan struct{}) error {
+	if a.CertFile == "" || a.KeyFile == "" {
+		return errors.New("a serving certificate and key are required to serve the audit webhook backend")
+	}
+	config, err := a.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(auditPath, a)
+	server := &http.Server{Addr: a.BindAddress, Handler: mux, TLSConfig: config}
+
+	errCh := make(chan error, 1)
+	go func() {
+		a.Log.Info("starting audit webhook receiver", "address", a.BindAddress, "path", auditPath)
+		if err := server.ListenAndServeTLS(a.CertFile, a.KeyFile); err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-stop:
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
+
+// NeedLeaderElection tells the receiver runs only on the leader, which runs the ResourceControllers
+// attributing the changes. The events sent to the other replicas could never be used, so the audit
+// webhook backend must reach the leader, i.e. the attribution needs a single replica.
+func (a *AuditReceiver) NeedLeaderElection() bool {
+	return true
+}
+
+// record indexes the events of the completed requests changing objects
+func (a *AuditReceiver) record(events []auditEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.byVersion == nil {
+		a.byVersion = make(map[string]*auditEntry)
+		a.byObject = make(map[string][]*auditEntry)
+	}
+
+	now := time.Now()
+	for i := range events {
+		e := &events[i]
+		if !isChange(e) {
+			continue
+		}
+
+		entry := &auditEntry{
+			event:      e,
+			uid:        e.ObjectRef.UID,
+			receivedAt: now,
+		}
+
+		// the response object tells the resourceVersion made by the request
+		// if the audit policy level is RequestResponse
+		var resp struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+		}
+		if len(e.ResponseObject) > 0 && json.Unmarshal(e.ResponseObject, &resp) == nil {
+			if resp.Metadata.UID != "" {
+				entry.uid = resp.Metadata.UID
+			}
+			entry.resourceVersion = resp.Metadata.ResourceVersion
+		}
+
+		if entry.uid != "" && entry.resourceVersion != "" && e.Verb != "delete" {
+			a.byVersion[versionKey(entry.uid, entry.resourceVersion)] = entry
+			continue
+		}
+
+		key := objectKey(e.ObjectRef.APIGroup, e.ObjectRef.Resource, e.ObjectRef.Namespace, e.ObjectRef.Name)
+		entries := append(a.byObject[key], entry)
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].event.StageTimestamp.Before(&entries[j].event.StageTimestamp)
+		})
+		a.byObject[key] = entries
+	}
+
+	a.prune(now)
+}
+
+// prune drops the entries and the waits older than auditRetention
+func (a *AuditReceiver) prune(now time.Time) {
+	for k, entry := range a.byVersion {
+		if now.Sub(entry.receivedAt) > auditRetention {
+			delete(a.byVersion, k)
+		}
+	}
+	for k, entries := range a.byObject {
+		kept := entries[:0]
+		for _, entry := range entries {
+			if now.Sub(entry.receivedAt) <= auditRetention {
+				kept = append(kept, entry)
+			}
+		}
+		if len(kept) == 0 {
+			delete(a.byObject, k)
+		} else {
+			a.byObject[k] = kept
+		}
+	}
+	for k, entry := range a.matched {
+		if now.Sub(entry.receivedAt) > auditRetention {
+			delete(a.matched, k)
+		}
+	}
+	for k, since := range a.waiting {
+		if now.Sub(since) > auditRetention {
+			delete(a.waiting, k)
+		}
+	}
+}
+
+// isChange reports whether the event is of a succeeded request changing an object
+func isChange(e *auditEvent) bool {
+	if e.Stage != "ResponseComplete" || e.ObjectRef == nil || e.ObjectRef.Name == "" {
+		return false
+	}
+	if e.ObjectRef.Subresource != "" {
+		return false
+	}
+	if e.ResponseStatus != nil && (e.ResponseStatus.Code < 200 || e.ResponseStatus.Code >= 300) {
+		return false
+	}
+
+	switch e.Verb {
+	case "create", "update", "patch", "delete":
+		return true
+	}
+	return false
+}
+
+// Attribute enriches the attribution of the captured change with the audit event of the request.
+// It never blocks: if the event has not been received yet, it returns how long the capture
+// should be delayed for the event, which is up to Wait since the change was first attributed.
+// Once Wait passes, the attribution is returned as it is.
+func (a *AuditReceiver) Attribute(gvk schema.GroupVersionKind, ref corev1.ObjectReference, deleted bool, attribution *capturerv1alpha1.Attribution) (*capturerv1alpha1.Attribution, time.Duration) {
+	mapping, err := a.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		a.Log.Error(err, "failed to map kind to resource", "gvk", gvk)
+		return attribution, 0
+	}
+	gvr := mapping.Resource
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := waitKey(gvr, ref, deleted)
+	if entry, ok := a.matched[key]; ok {
+		return enrich(attribution, entry.event), 0
+	}
+	if entry := a.lookup(gvr, ref, deleted, attribution); entry != nil {
+		delete(a.waiting, key)
+		if a.matched == nil {
+			a.matched = make(map[string]*auditEntry)
+		}
+		a.matched[key] = entry
+		return enrich(attribution, entry.event), 0
+	}
+
+	now := time.Now()
+	if a.waiting == nil {
+		a.waiting = make(map[string]time.Time)
+	}
+	since, ok := a.waiting[key]
+	if !ok {
+		a.prune(now)
+		since = now
+		a.waiting[key] = since
+	}
+
+	// the wait is kept after it passes, so that the change is not delayed again
+	if wait := a.Wait - now.Sub(since); wait > 0 {
+		return attribution, wait
+	}
+	return attribution, 0
+}
+
+// lookup finds and removes the entry of the request making the captured change.
+// The entries without resourceVersion are correlated by the object, and must be completed
+// no earlier than the change was made according to the attribution from managedFields.
+// The entry is removed with the older ones of the object, which are of the changes superseded by it.
+func (a *AuditReceiver) lookup(gvr schema.GroupVersionResource, ref corev1.ObjectReference, deleted bool, attribution *capturerv1alpha1.Attribution) *auditEntry {
+	if !deleted {
+		key := versionKey(ref.UID, ref.ResourceVersion)
+		if entry, ok := a.byVersion[key]; ok {
+			delete(a.byVersion, key)
+			return entry
+		}
+	}
+
+	key := objectKey(gvr.Group, gvr.Resource, ref.Namespace, ref.Name)
+	entries := a.byObject[key]
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !matchEntry(entries[i], ref, deleted, attribution) {
+			continue
+		}
+
+		if i == len(entries)-1 {
+			delete(a.byObject, key)
+		} else {
+			a.byObject[key] = entries[i+1:]
+		}
+		return entries[i]
+	}
+	return nil
+}
+
+// matchEntry reports whether the entry can be of the request making the captured change
+func matchEntry(entry *auditEntry, ref corev1.ObjectReference, deleted bool, attribution *capturerv1alpha1.Attribution) bool {
+	if entry.uid != "" && ref.UID != "" && entry.uid != ref.UID {
+		return false
+	}
+	if deleted {
+		return entry.event.Verb == "delete"
+	}
+	if entry.event.Verb == "delete" {
+		return false
+	}
+
+	// the time in managedFields is in seconds, and is set before the request completes
+	if attribution != nil && !attribution.Time.IsZero() {
+		return !entry.event.StageTimestamp.Time.Before(attribution.Time.Truncate(time.Second))
+	}
+	return true
+}
+
+// enrich adds the requesting user of the event to the attribution
+func enrich(attribution *capturerv1alpha1.Attribution, e *auditEvent) *capturerv1alpha1.Attribution {
+	enriched := capturerv1alpha1.Attribution{
+		Operation: strings.Title(e.Verb),
+		Time:      e.StageTimestamp.Time,
+	}
+	if attribution != nil {
+		enriched = *attribution
+	}
+
+	user := e.User
+	if e.ImpersonatedUser != nil {
+		user = *e.ImpersonatedUser
+	}
+	enriched.User = user.Username
+	enriched.Groups = user.Groups
+	enriched.UserAgent = e.UserAgent
+	enriched.SourceIPs = e.SourceIPs
+	return &enriched
+}
+
+func versionKey(uid types.UID, resourceVersion string) string {
+	return string(uid) + "/" + resourceVersion
+}
+
+// waitKey identifies the captured change waiting for its audit event
+func waitKey(gvr schema.GroupVersionResource, ref corev1.ObjectReference, deleted bool) string {
+	if deleted {
+		return "delete/" + objectKey(gvr.Group, gvr.Resource, ref.Namespace, ref.Name)
+	}
+	return objectKey(gvr.Group, gvr.Resource, ref.Namespace, ref.Name) + "/" + versionKey(ref.UID, ref.ResourceVersion)
+}
+
+func objectKey(group, resource, namespace, name string) string {
+	return strings.Join([]string{group, resource, namespace, name}, "/")
+}
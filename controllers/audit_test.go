@@ -0,0 +1,420 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+// recordedAuditEvents is an EventList sent by the audit webhook backend, which has
+// an update at RequestResponse level, a patch at Metadata level, a delete and a failed update
+const recordedAuditEvents = `{
+  "kind": "EventList",
+  "apiVersion": "audit.k8s.io/v1",
+  "items": [
+    {
+      "level": "RequestResponse",
+      "auditID": "5a4d9b7e-0f0a-4a3e-9a53-1f0c8c1d7c01",
+      "stage": "ResponseComplete",
+      "requestURI": "/apis/apps/v1/namespaces/kube-system/deployments/coredns",
+      "verb": "update",
+      "user": {"username": "alice", "groups": ["developers", "system:authenticated"]},
+      "sourceIPs": ["10.0.0.1"],
+      "userAgent": "kubectl/v1.20.0 (linux/amd64) kubernetes/af46c47",
+      "objectRef": {"resource": "deployments", "namespace": "kube-system", "name": "coredns", "apiGroup": "apps", "apiVersion": "v1"},
+      "responseStatus": {"metadata": {}, "code": 200},
+      "responseObject": {"kind": "Deployment", "apiVersion": "apps/v1", "metadata": {"name": "coredns", "namespace": "kube-system", "uid": "0b9a7e0c-2f5c-4c1a-8d53-3c5d3f8f0a11", "resourceVersion": "1001"}},
+      "requestReceivedTimestamp": "2021-03-01T12:34:56.000000Z",
+      "stageTimestamp": "2021-03-01T12:34:56.100000Z"
+    },
+    {
+      "level": "Metadata",
+      "auditID": "5a4d9b7e-0f0a-4a3e-9a53-1f0c8c1d7c02",
+      "stage": "ResponseComplete",
+      "requestURI": "/apis/apps/v1/namespaces/default/deployments/web",
+      "verb": "patch",
+      "user": {"username": "system:serviceaccount:ci:deployer", "groups": ["system:serviceaccounts"]},
+      "impersonatedUser": {"username": "bob", "groups": ["operators"]},
+      "sourceIPs": ["10.0.0.2"],
+      "userAgent": "argocd-application-controller/v0.0.0",
+      "objectRef": {"resource": "deployments", "namespace": "default", "name": "web", "apiGroup": "apps", "apiVersion": "v1"},
+      "responseStatus": {"metadata": {}, "code": 200},
+      "requestReceivedTimestamp": "2021-03-01T12:35:00.000000Z",
+      "stageTimestamp": "2021-03-01T12:35:00.100000Z"
+    },
+    {
+      "level": "Metadata",
+      "auditID": "5a4d9b7e-0f0a-4a3e-9a53-1f0c8c1d7c03",
+      "stage": "ResponseComplete",
+      "requestURI": "/apis/apps/v1/namespaces/default/deployments/legacy",
+      "verb": "delete",
+      "user": {"username": "carol", "groups": ["system:masters"]},
+      "sourceIPs": ["10.0.0.3"],
+      "userAgent": "kubectl/v1.20.0 (linux/amd64) kubernetes/af46c47",
+      "objectRef": {"resource": "deployments", "namespace": "default", "name": "legacy", "apiGroup": "apps", "apiVersion": "v1"},
+      "responseStatus": {"metadata": {}, "code": 200},
+      "requestReceivedTimestamp": "2021-03-01T12:36:00.000000Z",
+      "stageTimestamp": "2021-03-01T12:36:00.100000Z"
+    },
+    {
+      "level": "Metadata",
+      "auditID": "5a4d9b7e-0f0a-4a3e-9a53-1f0c8c1d7c04",
+      "stage": "ResponseComplete",
+      "requestURI": "/apis/apps/v1/namespaces/default/deployments/rejected",
+      "verb": "update",
+      "user": {"username": "mallory"},
+      "objectRef": {"resource": "deployments", "namespace": "default", "name": "rejected", "apiGroup": "apps", "apiVersion": "v1"},
+      "responseStatus": {"metadata": {}, "status": "Failure", "code": 403},
+      "requestReceivedTimestamp": "2021-03-01T12:37:00.000000Z",
+      "stageTimestamp": "2021-03-01T12:37:00.100000Z"
+    }
+  ]
+}`
+
+// auditToken is the bearer token of the audit webhook backend in the tests
+const auditToken = "audit-webhook-token"
+
+// postAuditEvents sends the EventList with the token as the audit webhook backend
+func postAuditEvents(client *http.Client, url, token, events string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url+auditPath, strings.NewReader(events))
+	Expect(err).NotTo(HaveOccurred())
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	Expect(err).NotTo(HaveOccurred())
+	resp.Body.Close()
+	return resp
+}
+
+// newCertificate issues a certificate for the client authentication signed by the parent,
+// or a self-signed CA if parent is nil
+func newCertificate(name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	issuer, signer := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	Expect(err).NotTo(HaveOccurred())
+	leaf, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+var _ = Describe("AuditReceiver", func() {
+	deployment := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+
+	var (
+		audit  *AuditReceiver
+		server *httptest.Server
+	)
+
+	BeforeEach(func() {
+		mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{deployment.GroupVersion()})
+		mapper.Add(deployment, meta.RESTScopeNamespace)
+
+		audit = &AuditReceiver{
+			Log:    logf.Log.WithName("audit"),
+			Token:  auditToken,
+			Mapper: mapper,
+			Wait:   100 * time.Millisecond,
+		}
+		server = httptest.NewServer(audit)
+
+		resp := postAuditEvents(server.Client(), server.URL, auditToken, recordedAuditEvents)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("correlates the event by uid and resourceVersion", func() {
+		ref := corev1.ObjectReference{
+			Kind:            "Deployment",
+			Namespace:       "kube-system",
+			Name:            "coredns",
+			UID:             "0b9a7e0c-2f5c-4c1a-8d53-3c5d3f8f0a11",
+			ResourceVersion: "1001",
+		}
+		attribution := &capturerv1alpha1.Attribution{Manager: "kubectl-edit", Operation: "Update"}
+
+		a, wait := audit.Attribute(deployment, ref, false, attribution)
+		Expect(wait).To(BeZero())
+		Expect(a.Manager).To(Equal("kubectl-edit"))
+		Expect(a.User).To(Equal("alice"))
+		Expect(a.Groups).To(Equal([]string{"developers", "system:authenticated"}))
+		Expect(a.SourceIPs).To(Equal([]string{"10.0.0.1"}))
+		Expect(a.UserAgent).To(HavePrefix("kubectl/v1.20.0"))
+	})
+
+	It("does not correlate the event of another resourceVersion", func() {
+		ref := corev1.ObjectReference{
+			Kind:            "Deployment",
+			Namespace:       "kube-system",
+			Name:            "coredns",
+			UID:             "0b9a7e0c-2f5c-4c1a-8d53-3c5d3f8f0a11",
+			ResourceVersion: "1002",
+		}
+
+		a, wait := audit.Attribute(deployment, ref, false, nil)
+		Expect(wait).To(BeNumerically(">", 0))
+		Expect(a).To(BeNil())
+	})
+
+	It("correlates the event without the response object by the object, preferring the impersonated user", func() {
+		ref := corev1.ObjectReference{
+			Kind:            "Deployment",
+			Namespace:       "default",
+			Name:            "web",
+			UID:             "7c1d1e0e-9b4a-4b0e-a0c8-0d4b1f6f2b22",
+			ResourceVersion: "2001",
+		}
+
+		a, wait := audit.Attribute(deployment, ref, false, nil)
+		Expect(wait).To(BeZero())
+		Expect(a).NotTo(BeNil())
+		Expect(a.Operation).To(Equal("Patch"))
+		Expect(a.User).To(Equal("bob"))
+		Expect(a.Groups).To(Equal([]string{"operators"}))
+	})
+
+	It("does not correlate the event without the response object with a later change of the object", func() {
+		ref := corev1.ObjectReference{
+			Kind:            "Deployment",
+			Namespace:       "default",
+			Name:            "web",
+			UID:             "7c1d1e0e-9b4a-4b0e-a0c8-0d4b1f6f2b22",
+			ResourceVersion: "2002",
+		}
+		attribution := &capturerv1alpha1.Attribution{
+			Manager:   "kubectl-edit",
+			Operation: "Update",
+			Time:      time.Date(2021, 3, 1, 12, 40, 0, 0, time.UTC),
+		}
+
+		audit.Wait = 0
+		a, wait := audit.Attribute(deployment, ref, false, attribution)
+		Expect(wait).To(BeZero())
+		Expect(a.Manager).To(Equal("kubectl-edit"))
+		Expect(a.User).To(BeEmpty())
+	})
+
+	It("correlates the event without the response object with a single change of the object", func() {
+		ref := corev1.ObjectReference{
+			Kind:            "Deployment",
+			Namespace:       "default",
+			Name:            "web",
+			UID:             "7c1d1e0e-9b4a-4b0e-a0c8-0d4b1f6f2b22",
+			ResourceVersion: "2001",
+		}
+		attribution := &capturerv1alpha1.Attribution{
+			Manager:   "argocd-controller",
+			Operation: "Update",
+			Time:      time.Date(2021, 3, 1, 12, 35, 0, 0, time.UTC),
+		}
+
+		a, wait := audit.Attribute(deployment, ref, false, attribution)
+		Expect(wait).To(BeZero())
+		Expect(a.User).To(Equal("bob"))
+
+		By("attributing the retried capture of the change again")
+		a, wait = audit.Attribute(deployment, ref, false, attribution)
+		Expect(wait).To(BeZero())
+		Expect(a.User).To(Equal("bob"))
+
+		By("waiting for the event of the next change")
+		ref.ResourceVersion = "2002"
+		a, wait = audit.Attribute(deployment, ref, false, nil)
+		Expect(wait).To(BeNumerically(">", 0))
+		Expect(a).To(BeNil())
+	})
+
+	It("correlates the delete event with the deleted object", func() {
+		ref := corev1.ObjectReference{
+			Kind:      "Deployment",
+			Namespace: "default",
+			Name:      "legacy",
+		}
+
+		a, wait := audit.Attribute(deployment, ref, true, nil)
+		Expect(wait).To(BeZero())
+		Expect(a).NotTo(BeNil())
+		Expect(a.Operation).To(Equal("Delete"))
+		Expect(a.User).To(Equal("carol"))
+		Expect(a.Requester()).To(HavePrefix("carol (system:masters) from 10.0.0.3 with kubectl/v1.20.0"))
+	})
+
+	It("ignores the events of failed requests", func() {
+		ref := corev1.ObjectReference{
+			Kind:      "Deployment",
+			Namespace: "default",
+			Name:      "rejected",
+		}
+
+		a, wait := audit.Attribute(deployment, ref, false, nil)
+		Expect(wait).To(BeNumerically(">", 0))
+		Expect(a).To(BeNil())
+	})
+
+	It("delays the capture until the event sent after it is received", func() {
+		audit.Wait = 5 * time.Second
+		ref := corev1.ObjectReference{
+			Kind:            "Deployment",
+			Namespace:       "default",
+			Name:            "late",
+			UID:             "2d6f4c1e-8a3b-4e5d-9c7f-1b2a3c4d5e6f",
+			ResourceVersion: "3001",
+		}
+
+		a, wait := audit.Attribute(deployment, ref, false, nil)
+		Expect(a).To(BeNil())
+		Expect(wait).To(BeNumerically(">", 0))
+		Expect(wait).To(BeNumerically("<=", audit.Wait))
+
+		events := `{"kind": "EventList", "apiVersion": "audit.k8s.io/v1", "items": [{
+		  "auditID": "5a4d9b7e-0f0a-4a3e-9a53-1f0c8c1d7c05",
+		  "stage": "ResponseComplete",
+		  "verb": "create",
+		  "user": {"username": "dave"},
+		  "objectRef": {"resource": "deployments", "namespace": "default", "name": "late", "apiGroup": "apps", "apiVersion": "v1"},
+		  "responseStatus": {"metadata": {}, "code": 201},
+		  "stageTimestamp": "2021-03-01T12:38:00.100000Z"
+		}]}`
+		resp := postAuditEvents(server.Client(), server.URL, auditToken, events)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		a, wait = audit.Attribute(deployment, ref, false, nil)
+		Expect(wait).To(BeZero())
+		Expect(a).NotTo(BeNil())
+		Expect(a.User).To(Equal("dave"))
+	})
+
+	It("captures without the event once the wait passes", func() {
+		ref := corev1.ObjectReference{
+			Kind:            "Deployment",
+			Namespace:       "default",
+			Name:            "unaudited",
+			ResourceVersion: "4001",
+		}
+		attribution := &capturerv1alpha1.Attribution{Manager: "kubectl-edit", Operation: "Update"}
+
+		_, wait := audit.Attribute(deployment, ref, false, attribution)
+		Expect(wait).To(BeNumerically(">", 0))
+
+		time.Sleep(wait)
+		a, wait := audit.Attribute(deployment, ref, false, attribution)
+		Expect(wait).To(BeZero())
+		Expect(a).To(Equal(attribution))
+
+		// the same change is never delayed again
+		_, wait = audit.Attribute(deployment, ref, false, attribution)
+		Expect(wait).To(BeZero())
+	})
+
+	It("rejects the events without the token", func() {
+		resp := postAuditEvents(server.Client(), server.URL, "", recordedAuditEvents)
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+		resp = postAuditEvents(server.Client(), server.URL, "another-token", recordedAuditEvents)
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("accepts the events with a client certificate signed by the client CA", func() {
+		ca := newCertificate("audit-ca", nil)
+		dir, err := ioutil.TempDir("", "audit")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		caFile := filepath.Join(dir, "ca.crt")
+		Expect(ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0600)).To(Succeed())
+
+		receiver := &AuditReceiver{
+			Log:          logf.Log.WithName("audit"),
+			ClientCAFile: caFile,
+		}
+		config, err := receiver.tlsConfig()
+		Expect(err).NotTo(HaveOccurred())
+
+		tlsServer := httptest.NewUnstartedServer(receiver)
+		tlsServer.TLS = config
+		tlsServer.StartTLS()
+		defer tlsServer.Close()
+
+		client := tlsServer.Client()
+		resp := postAuditEvents(client, tlsServer.URL, "", recordedAuditEvents)
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+		cert := newCertificate("kube-apiserver", &ca)
+		client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{cert}
+		client.Transport.(*http.Transport).CloseIdleConnections()
+		resp = postAuditEvents(client, tlsServer.URL, "", recordedAuditEvents)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		untrusted := newCertificate("kube-apiserver", nil)
+		client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{untrusted}
+		client.Transport.(*http.Transport).CloseIdleConnections()
+		resp = postAuditEvents(client, tlsServer.URL, "", recordedAuditEvents)
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("requires a client CA or a token", func() {
+		receiver := &AuditReceiver{Log: logf.Log.WithName("audit")}
+		_, err := receiver.tlsConfig()
+		Expect(err).To(HaveOccurred())
+	})
+})
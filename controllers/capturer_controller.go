@@ -0,0 +1,261 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+// CapturerController reconciles a Capturer object.
+// It starts a ResourceController for every GroupVersionKind referred by Capturers.
+type CapturerController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// APIReader reads the manifest stores bypassing the cache
+	APIReader client.Reader
+
+	// Audit enriches the captured changes with the requesting users if set
+	Audit *AuditReceiver
+
+	mgr      ctrl.Manager
+	mu       sync.Mutex
+	watching map[schema.GroupVersionKind]struct{}
+}
+
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=capturers,verbs=get;list;watch
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=capturers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=outputs,verbs=get;list
+
+func (r *CapturerController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("capturer", req.NamespacedName)
+
+	var c capturerv1alpha1.Capturer
+	if err := r.Get(ctx, req.NamespacedName, &c); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	if err := validateSpec(&c); err != nil {
+		log.Error(err, "invalid Capturer")
+		if c.Status.ObservedGeneration != c.GetGeneration() {
+			if serr := updateCapturerStatus(ctx, r, &c, func(latest *capturerv1alpha1.Capturer) {
+				setInvalidSpecStatus(latest, c.GetGeneration(), err)
+			}); serr != nil {
+				return ctrl.Result{}, serr
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	gvk, err := c.ResourceGroupVersionKind()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = r.watch(gvk); err != nil {
+		log.Error(err, "failed to watch resource", "gvk", gvk)
+		return ctrl.Result{}, err
+	}
+
+	if c.Status.ObservedGeneration != c.GetGeneration() {
+		if err = r.observe(ctx, log, &c); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	result := ctrl.Result{}
+	if c.Spec.Schedule != "" {
+		if result, err = r.schedule(ctx, log, &c); err != nil {
+			return result, err
+		}
+	}
+
+	expiry, err := expireSnapshots(ctx, r, r.APIReader, &c)
+	if err != nil {
+		log.Error(err, "failed to prune snapshots")
+		return ctrl.Result{}, err
+	}
+	if expiry > 0 && (result.RequeueAfter == 0 || expiry < result.RequeueAfter) {
+		result.RequeueAfter = expiry
+	}
+	return result, nil
+}
+
+// validateSpec checks the parts of the Capturer spec which the CRD schema cannot validate
+func validateSpec(c *capturerv1alpha1.Capturer) error {
+	if _, err := c.ResourceGroupVersionKind(); err != nil {
+		return err
+	}
+	if err := validateMatch(c); err != nil {
+		return err
+	}
+
+	if c.Spec.Schedule != "" {
+		sched, err := cron.ParseStandard(c.Spec.Schedule)
+		if err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+		// Next returns the zero time if nothing matches in 5 years, e.g. on February 30th
+		if sched.Next(time.Now()).IsZero() {
+			return fmt.Errorf("schedule %q never matches", c.Spec.Schedule)
+		}
+	}
+	return nil
+}
+
+// observe validates the Outputs and captures the current state of the targets
+// when the Capturer is created or its spec is changed
+func (r *CapturerController) observe(ctx context.Context, log logr.Logger, c *capturerv1alpha1.Capturer) error {
+	unresolved := []string{}
+	invalid := []error{}
+	for _, name := range c.Spec.Outputs {
+		var output capturerv1alpha1.Output
+		key := types.NamespacedName{Namespace: c.GetNamespace(), Name: name}
+		if err := r.Get(ctx, key, &output); err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			unresolved = append(unresolved, name)
+			continue
+		}
+
+		if err := output.ValidateCapturer(c); err != nil {
+			invalid = append(invalid, fmt.Errorf("output %s: %w", name, err))
+		}
+	}
+
+	if len(invalid) > 0 {
+		err := utilerrors.NewAggregate(invalid)
+		log.Error(err, "invalid Capturer")
+		return updateCapturerStatus(ctx, r, c, func(latest *capturerv1alpha1.Capturer) {
+			setInvalidSpecStatus(latest, c.GetGeneration(), err)
+		})
+	}
+
+	log.Info("capturing targets", "generation", c.GetGeneration())
+	if err := snapshotTargets(ctx, r, r.APIReader, c); err != nil {
+		log.Error(err, "failed to capture targets")
+		return err
+	}
+
+	return updateCapturerStatus(ctx, r, c, func(latest *capturerv1alpha1.Capturer) {
+		setObservedStatus(latest, c.GetGeneration(), unresolved)
+	})
+}
+
+// schedule takes a full snapshot if the scheduled time has come, and requeues the Capturer
+// for the next one. The snapshots missed while the controller is down are taken at once.
+func (r *CapturerController) schedule(ctx context.Context, log logr.Logger, c *capturerv1alpha1.Capturer) (ctrl.Result, error) {
+	sched, err := cron.ParseStandard(c.Spec.Schedule)
+	if err != nil {
+		log.Error(err, "invalid schedule", "schedule", c.Spec.Schedule)
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+	last := c.GetCreationTimestamp().Time
+	if c.Status.LastScheduleTime != nil {
+		last = c.Status.LastScheduleTime.Time
+	}
+
+	next := sched.Next(last)
+	if next.IsZero() {
+		log.Info("schedule never matches", "schedule", c.Spec.Schedule)
+		return ctrl.Result{}, nil
+	}
+	if next.After(now) {
+		return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+	}
+
+	log.Info("taking scheduled snapshot", "scheduledAt", next)
+	if err = snapshotTargets(ctx, r, r.APIReader, c); err != nil {
+		log.Error(err, "failed to take scheduled snapshot")
+		return ctrl.Result{}, err
+	}
+
+	if err = updateCapturerStatus(ctx, r, c, func(latest *capturerv1alpha1.Capturer) {
+		t := metav1.NewTime(now)
+		latest.Status.LastScheduleTime = &t
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	next = sched.Next(now)
+	if next.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+}
+
+// watch starts a ResourceController for gvk unless it is already running
+func (r *CapturerController) watch(gvk schema.GroupVersionKind) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.watching[gvk]; ok {
+		return nil
+	}
+
+	if err := (&ResourceController{
+		Client:           r.mgr.GetClient(),
+		Log:              r.Log.WithName(controllerName(gvk)),
+		Scheme:           r.mgr.GetScheme(),
+		GroupVersionKind: gvk,
+		APIReader:        r.APIReader,
+		Audit:            r.Audit,
+	}).SetupWithManager(r.mgr); err != nil {
+		return err
+	}
+
+	r.watching[gvk] = struct{}{}
+	return nil
+}
+
+func (r *CapturerController) SetupWithManager(mgr ctrl.Manager) error {
+	r.mgr = mgr
+	r.watching = make(map[schema.GroupVersionKind]struct{})
+
+	haveGeneration := true
+	return ctrl.NewControllerManagedBy(mgr).
+		For(
+			&capturerv1alpha1.Capturer{},
+			builder.WithPredicates(Predicates(haveGeneration)),
+		).
+		Complete(r)
+}
@@ -0,0 +1,187 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("CapturerController", func() {
+	var (
+		ctx context.Context
+		cc  *CapturerController
+		req ctrl.Request
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(capturerv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		c := &capturerv1alpha1.Capturer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "coredns-capturer", UID: "3f0c5a8e-8f0d-4d5c-9a0e-6b1f2c3d4e5f", Generation: 1},
+			Spec: capturerv1alpha1.CapturerSpec{
+				NamespacedResource: true,
+				ResourceKind:       "ConfigMap",
+				ResourceName:       "coredns",
+				History:            &capturerv1alpha1.HistoryPolicy{MaxCount: 5},
+			},
+		}
+		r := fake.NewFakeClientWithScheme(scheme, c,
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "coredns"},
+				Data:       map[string]string{"Corefile": ".:53 {}"},
+			},
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "kube-proxy"},
+				Data:       map[string]string{"config.conf": "mode: iptables"},
+			},
+		)
+		cc = &CapturerController{
+			Client:    r,
+			Log:       ctrl.Log.WithName("test"),
+			Scheme:    scheme,
+			APIReader: r,
+			// the ResourceController of ConfigMaps needs a manager, which is out of the tests
+			watching: map[schema.GroupVersionKind]struct{}{{Version: "v1", Kind: "ConfigMap"}: {}},
+		}
+		req = ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "coredns-capturer"}}
+	})
+
+	// history returns the ManifestSnapshots of the object kept by the Capturer, the latest first
+	history := func(name string) []capturerv1alpha1.ManifestSnapshot {
+		var c capturerv1alpha1.Capturer
+		Expect(cc.Get(ctx, req.NamespacedName, &c)).To(Succeed())
+		snapshots, err := listSnapshots(ctx, cc, &c)
+		Expect(err).NotTo(HaveOccurred())
+		return snapshots["ConfigMap.default."+name]
+	}
+
+	It("captures the existing targets when the Capturer is created", func() {
+		_, err := cc.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+
+		snapshots := history("coredns")
+		Expect(snapshots).To(HaveLen(1))
+		Expect(snapshots[0].Spec.Manifest).To(ContainSubstring("Corefile"))
+		Expect(history("kube-proxy")).To(BeEmpty())
+
+		var c capturerv1alpha1.Capturer
+		Expect(cc.Get(ctx, req.NamespacedName, &c)).To(Succeed())
+		Expect(c.Status.ObservedGeneration).To(Equal(int64(1)))
+
+		By("not capturing them again for the same generation")
+		cm := &corev1.ConfigMap{}
+		Expect(cc.Get(ctx, types.NamespacedName{Namespace: "default", Name: "coredns"}, cm)).To(Succeed())
+		cm.Data["Corefile"] = ".:5353 {}"
+		Expect(cc.Update(ctx, cm)).To(Succeed())
+
+		_, err = cc.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(history("coredns")).To(HaveLen(1))
+	})
+
+	It("captures the existing targets again when the generation of the Capturer changes", func() {
+		_, err := cc.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(history("coredns")).To(HaveLen(1))
+
+		cm := &corev1.ConfigMap{}
+		Expect(cc.Get(ctx, types.NamespacedName{Namespace: "default", Name: "coredns"}, cm)).To(Succeed())
+		cm.Data["Corefile"] = ".:5353 {}"
+		Expect(cc.Update(ctx, cm)).To(Succeed())
+
+		var c capturerv1alpha1.Capturer
+		Expect(cc.Get(ctx, req.NamespacedName, &c)).To(Succeed())
+		c.Spec.ResourceNamePatterns = []string{"kube-*"}
+		c.Generation = 2
+		Expect(cc.Update(ctx, &c)).To(Succeed())
+
+		_, err = cc.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+
+		snapshots := history("coredns")
+		Expect(snapshots).To(HaveLen(2))
+		Expect(snapshots[0].Spec.Manifest).To(ContainSubstring(".:5353 {}"))
+		Expect(history("kube-proxy")).To(HaveLen(1))
+
+		Expect(cc.Get(ctx, req.NamespacedName, &c)).To(Succeed())
+		Expect(c.Status.ObservedGeneration).To(Equal(int64(2)))
+	})
+
+	It("prunes the snapshots older than the maximum age without a new capture", func() {
+		var c capturerv1alpha1.Capturer
+		Expect(cc.Get(ctx, req.NamespacedName, &c)).To(Succeed())
+		c.Spec.History = &capturerv1alpha1.HistoryPolicy{MaxAge: &metav1.Duration{Duration: time.Hour}}
+		c.Status.ObservedGeneration = c.GetGeneration()
+		Expect(cc.Update(ctx, &c)).To(Succeed())
+
+		now := time.Now()
+		for sequence, age := range map[int64]time.Duration{1: 3 * time.Hour, 2: 2 * time.Hour, 3: 30 * time.Minute, 4: 2 * time.Hour} {
+			Expect(cc.Create(ctx, &capturerv1alpha1.ManifestSnapshot{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:    "default",
+					GenerateName: "coredns-capturer-",
+					Labels:       map[string]string{manifestStoreLabel: string(c.GetUID())},
+				},
+				Spec: capturerv1alpha1.ManifestSnapshotSpec{
+					Capturer:   c.GetName(),
+					Object:     corev1.ObjectReference{Kind: "ConfigMap", Namespace: "default", Name: "coredns"},
+					CapturedAt: metav1.NewTime(now.Add(-age)),
+					Sequence:   sequence,
+				},
+			})).To(Succeed())
+		}
+
+		result, err := cc.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the latest one is kept even if expired, and the next one expires in 30 minutes
+		snapshots := history("coredns")
+		Expect(snapshots).To(HaveLen(2))
+		Expect(snapshots[0].Spec.Sequence).To(Equal(int64(4)))
+		Expect(snapshots[1].Spec.Sequence).To(Equal(int64(3)))
+		Expect(result.RequeueAfter).To(BeNumerically("~", 30*time.Minute, time.Minute))
+	})
+
+	It("requeues the Capturer to prune the snapshots at the latest after the maximum age", func() {
+		var c capturerv1alpha1.Capturer
+		Expect(cc.Get(ctx, req.NamespacedName, &c)).To(Succeed())
+		c.Spec.History = &capturerv1alpha1.HistoryPolicy{MaxAge: &metav1.Duration{Duration: time.Hour}}
+		Expect(cc.Update(ctx, &c)).To(Succeed())
+
+		result, err := cc.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(history("coredns")).To(HaveLen(1))
+		Expect(result.RequeueAfter).To(Equal(time.Hour))
+	})
+})
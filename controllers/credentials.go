@@ -0,0 +1,103 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+// CredentialsPredicates filters the events of the Secrets which may be referred by Outputs.
+// The manifest stores are dropped since they are rewritten on every capture and never hold credentials.
+var CredentialsPredicates predicate.Predicate = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return !isManifestStore(e.Object)
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		return !isManifestStore(e.Object)
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return !isManifestStore(e.ObjectNew)
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return !isManifestStore(e.Object)
+	},
+}
+
+// isManifestStore reports whether the object is a Secret storing the manifest last captured
+func isManifestStore(obj runtime.Object) bool {
+	secret, ok := obj.(*corev1.Secret)
+	return ok && secret.Type == manifestStoreType
+}
+
+// resolveCredentials reads the credentials of the Output from the Secret referred by it,
+// and returns nil if it refers none. The credentials are only kept by the controllers and passed to the Output,
+// and the Secret is read every time the Output is used, so that its rotation is picked up without restart.
+func resolveCredentials(ctx context.Context, r client.Client, o *capturerv1alpha1.Output) (*capturerv1alpha1.GitCredentials, error) {
+	gh := o.Spec.GitHub
+	if gh == nil || gh.Config.SecretRef == nil {
+		return nil, nil
+	}
+
+	var secret corev1.Secret
+	key := types.NamespacedName{Namespace: o.GetNamespace(), Name: gh.Config.SecretRef.Name}
+	if err := r.Get(ctx, key, &secret); err != nil {
+		return nil, &capturerv1alpha1.OutputError{
+			Reason: capturerv1alpha1.ReasonInvalidCredentials,
+			Err:    fmt.Errorf("failed to get Secret %s: %w", key, err),
+		}
+	}
+
+	creds, err := capturerv1alpha1.NewGitCredentials(secret.Data)
+	if err != nil {
+		return nil, &capturerv1alpha1.OutputError{
+			Reason: capturerv1alpha1.ReasonInvalidCredentials,
+			Err:    fmt.Errorf("invalid credentials in Secret %s: %w", key, err),
+		}
+	}
+	return creds, nil
+}
+
+// outputsReferring returns the requests of the Outputs in the namespace of the Secret referring it by secretRef
+func outputsReferring(ctx context.Context, r client.Reader, secret types.NamespacedName) ([]reconcile.Request, error) {
+	var outputs capturerv1alpha1.OutputList
+	if err := r.List(ctx, &outputs, client.InNamespace(secret.Namespace)); err != nil {
+		return nil, err
+	}
+
+	requests := []reconcile.Request{}
+	for _, o := range outputs.Items {
+		gh := o.Spec.GitHub
+		if gh == nil || gh.Config.SecretRef == nil || gh.Config.SecretRef.Name != secret.Name {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: o.GetNamespace(), Name: o.GetName()},
+		})
+	}
+	return requests, nil
+}
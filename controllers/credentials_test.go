@@ -0,0 +1,131 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("credentials", func() {
+	var (
+		ctx context.Context
+		r   client.Client
+	)
+
+	// githubOutput returns the GitHub Output referring the Secret, or none if secret is empty
+	githubOutput := func(namespace, name, secret string) *capturerv1alpha1.Output {
+		o := &capturerv1alpha1.Output{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec: capturerv1alpha1.OutputSpec{
+				GitHub: &capturerv1alpha1.GitHubOutput{
+					Config: capturerv1alpha1.GitHubConfig{RepositoryURL: "https://github.com/terakoya76/manifests.git"},
+				},
+			},
+		}
+		if secret != "" {
+			o.Spec.GitHub.Config.SecretRef = &corev1.LocalObjectReference{Name: secret}
+		}
+		return o
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(capturerv1alpha1.AddToScheme(scheme)).To(Succeed())
+		r = fake.NewFakeClientWithScheme(scheme,
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "github-credentials"},
+				Data:       map[string][]byte{"token": []byte("ghp_token")},
+			},
+			githubOutput("default", "github-output", "github-credentials"),
+			githubOutput("default", "another-output", "another-credentials"),
+			githubOutput("default", "public-output", ""),
+			githubOutput("other", "github-output", "github-credentials"),
+			&capturerv1alpha1.Output{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "slack-output"},
+				Spec: capturerv1alpha1.OutputSpec{
+					Slack: &capturerv1alpha1.SlackOutput{WebhookURL: "https://hooks.slack.com/services/T0/B0/X"},
+				},
+			},
+		)
+	})
+
+	It("resolves the credentials of the Output without keeping them in it", func() {
+		o := githubOutput("default", "github-output", "github-credentials")
+		creds, err := resolveCredentials(ctx, r, o)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds.Token).To(Equal("ghp_token"))
+
+		creds, err = resolveCredentials(ctx, r, githubOutput("default", "public-output", ""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(creds).To(BeNil())
+
+		_, err = resolveCredentials(ctx, r, githubOutput("default", "another-output", "another-credentials"))
+		Expect(capturerv1alpha1.ErrorReason(err, "")).To(Equal(capturerv1alpha1.ReasonInvalidCredentials))
+	})
+
+	It("maps the Secret to the Outputs referring it in its namespace", func() {
+		requests, err := outputsReferring(ctx, r, types.NamespacedName{Namespace: "default", Name: "github-credentials"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests).To(ConsistOf(reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: "default", Name: "github-output"},
+		}))
+
+		requests, err = outputsReferring(ctx, r, types.NamespacedName{Namespace: "default", Name: "unreferred"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requests).To(BeEmpty())
+	})
+})
+
+var _ = Describe("CredentialsPredicates", func() {
+	secret := func(secretType corev1.SecretType) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "github-credentials"},
+			Type:       secretType,
+		}
+	}
+
+	It("drops the events of the manifest stores", func() {
+		store := secret(manifestStoreType)
+		Expect(CredentialsPredicates.Create(event.CreateEvent{Meta: store, Object: store})).To(BeFalse())
+		Expect(CredentialsPredicates.Update(event.UpdateEvent{MetaOld: store, ObjectOld: store, MetaNew: store, ObjectNew: store})).To(BeFalse())
+		Expect(CredentialsPredicates.Delete(event.DeleteEvent{Meta: store, Object: store})).To(BeFalse())
+	})
+
+	It("passes the events of the other Secrets", func() {
+		for _, s := range []*corev1.Secret{secret(corev1.SecretTypeOpaque), secret(corev1.SecretTypeSSHAuth), secret("")} {
+			Expect(CredentialsPredicates.Create(event.CreateEvent{Meta: s, Object: s})).To(BeTrue())
+			Expect(CredentialsPredicates.Update(event.UpdateEvent{MetaOld: s, ObjectOld: s, MetaNew: s, ObjectNew: s})).To(BeTrue())
+			Expect(CredentialsPredicates.Delete(event.DeleteEvent{Meta: s, Object: s})).To(BeTrue())
+		}
+	})
+})
@@ -0,0 +1,128 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("schedule", func() {
+	// Monday
+	now := time.Date(2021, time.March, 1, 12, 34, 56, 0, time.UTC)
+
+	DescribeTable("finds the next time",
+		func(spec string, from time.Time, expected time.Time) {
+			sched, err := cron.ParseStandard(spec)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sched.Next(from)).To(Equal(expected))
+		},
+		Entry("every minute", "* * * * *", now,
+			time.Date(2021, time.March, 1, 12, 35, 0, 0, time.UTC)),
+		Entry("a step of minutes", "*/15 * * * *", now,
+			time.Date(2021, time.March, 1, 12, 45, 0, 0, time.UTC)),
+		Entry("strictly after the matching time", "*/15 * * * *", time.Date(2021, time.March, 1, 12, 45, 0, 0, time.UTC),
+			time.Date(2021, time.March, 1, 13, 0, 0, 0, time.UTC)),
+		Entry("a step of hours", "0 */6 * * *", now,
+			time.Date(2021, time.March, 1, 18, 0, 0, 0, time.UTC)),
+		Entry("a range with a step", "30 9-17/4 * * *", now,
+			time.Date(2021, time.March, 1, 13, 30, 0, 0, time.UTC)),
+		Entry("a value with a step to the end", "0 5/10 * * *", now,
+			time.Date(2021, time.March, 1, 15, 0, 0, 0, time.UTC)),
+		Entry("a list of days of month", "0 0 1,15 * *", now,
+			time.Date(2021, time.March, 15, 0, 0, 0, 0, time.UTC)),
+		Entry("a range of days of week", "0 0 * * mon-fri", time.Date(2021, time.March, 5, 12, 0, 0, 0, time.UTC),
+			time.Date(2021, time.March, 8, 0, 0, 0, 0, time.UTC)),
+		Entry("the names of months in any case", "0 0 1 JAN,Jul *", now,
+			time.Date(2021, time.July, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("a leap day", "0 0 29 2 *", now,
+			time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)),
+		Entry("either the day of month or the day of week if both are restricted", "0 0 13 * fri", now,
+			time.Date(2021, time.March, 5, 0, 0, 0, 0, time.UTC)),
+		Entry("either the days if the day of month is a star with a step", "0 0 */2 * mon", now,
+			time.Date(2021, time.March, 3, 0, 0, 0, 0, time.UTC)),
+		Entry("the day of week only if the day of month is a star", "0 0 * * mon", now,
+			time.Date(2021, time.March, 8, 0, 0, 0, 0, time.UTC)),
+		Entry("the day of month only if the day of week is a star", "0 0 13 * *", now,
+			time.Date(2021, time.March, 13, 0, 0, 0, 0, time.UTC)),
+		Entry("a list of ranges with steps", "0 0-5/2,20-23/3 * * *", now,
+			time.Date(2021, time.March, 1, 20, 0, 0, 0, time.UTC)),
+		Entry("@hourly", "@hourly", now,
+			time.Date(2021, time.March, 1, 13, 0, 0, 0, time.UTC)),
+		Entry("@daily", "@daily", now,
+			time.Date(2021, time.March, 2, 0, 0, 0, 0, time.UTC)),
+		Entry("@weekly", "@weekly", now,
+			time.Date(2021, time.March, 7, 0, 0, 0, 0, time.UTC)),
+		Entry("@monthly", "@monthly", now,
+			time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("@yearly", "@yearly", now,
+			time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("@every", "@every 5m", now,
+			time.Date(2021, time.March, 1, 12, 39, 56, 0, time.UTC)),
+		Entry("never on February 30th", "0 0 30 2 *", now, time.Time{}),
+		Entry("never on April 31st", "0 0 31 apr *", now, time.Time{}),
+	)
+
+	DescribeTable("rejects the invalid schedules",
+		func(spec string) {
+			_, err := cron.ParseStandard(spec)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("too few fields", "* * * *"),
+		Entry("too many fields", "0 * * * * *"),
+		Entry("a minute out of range", "60 * * * *"),
+		Entry("a day of week out of range", "* * * * 8"),
+		Entry("a day of month out of range", "* * 0 * *"),
+		Entry("a zero step", "*/0 * * * *"),
+		Entry("a reversed range", "5-1 * * * *"),
+		Entry("an unknown name", "* * * foo *"),
+		Entry("an unknown descriptor", "@fortnightly"),
+		Entry("an invalid interval", "@every 5 minutes"),
+	)
+
+	It("reports the invalid schedule as the invalid spec of the Capturer", func() {
+		c := &capturerv1alpha1.Capturer{
+			ObjectMeta: metav1.ObjectMeta{Generation: 2},
+			Spec: capturerv1alpha1.CapturerSpec{
+				ResourceKind: "ConfigMap",
+				ResourceName: "coredns",
+				Schedule:     "0 0 * *",
+			},
+		}
+		Expect(validateSpec(c)).To(MatchError(ContainSubstring("invalid schedule")))
+
+		c.Spec.Schedule = "0 0 30 2 *"
+		err := validateSpec(c)
+		Expect(err).To(MatchError(ContainSubstring("never matches")))
+
+		setInvalidSpecStatus(c, c.GetGeneration(), err)
+		ready := capturerv1alpha1.FindCondition(c.Status.Conditions, capturerv1alpha1.CapturerReady)
+		Expect(ready).NotTo(BeNil())
+		Expect(ready.Reason).To(Equal(capturerv1alpha1.ReasonInvalidSpec))
+		Expect(c.Status.ObservedGeneration).To(Equal(int64(2)))
+
+		c.Spec.Schedule = "@daily"
+		Expect(validateSpec(c)).To(Succeed())
+	})
+})
@@ -0,0 +1,151 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// numberedLines returns the lines `line1` to `line<n>`, replacing the lines in changes
+func numberedLines(n int, changes map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if text, ok := changes[i]; ok {
+			b.WriteString(text + "\n")
+			continue
+		}
+		fmt.Fprintf(&b, "line%d\n", i)
+	}
+	return b.String()
+}
+
+var _ = Describe("unifiedDiff", func() {
+	DescribeTable("writes the hunks of the changes",
+		func(previous, current, expected string) {
+			Expect(unifiedDiff([]byte(previous), []byte(current))).To(Equal(expected))
+		},
+		Entry("nothing for the same manifests", numberedLines(10, nil), numberedLines(10, nil), ""),
+		Entry("a change with the lines around it",
+			numberedLines(10, nil), numberedLines(10, map[int]string{5: "five"}), `--- previous
++++ current
+@@ -2,7 +2,7 @@
+ line2
+ line3
+ line4
+-line5
++five
+ line6
+ line7
+ line8
+`),
+		Entry("the changes within twice the context in a hunk",
+			numberedLines(20, nil), numberedLines(20, map[int]string{5: "five", 11: "eleven"}), `--- previous
++++ current
+@@ -2,13 +2,13 @@
+ line2
+ line3
+ line4
+-line5
++five
+ line6
+ line7
+ line8
+ line9
+ line10
+-line11
++eleven
+ line12
+ line13
+ line14
+`),
+		Entry("the changes separated by exactly twice the context in a hunk",
+			numberedLines(20, nil), numberedLines(20, map[int]string{3: "three", 10: "ten"}), `--- previous
++++ current
+@@ -1,13 +1,13 @@
+ line1
+ line2
+-line3
++three
+ line4
+ line5
+ line6
+ line7
+ line8
+ line9
+-line10
++ten
+ line11
+ line12
+ line13
+`),
+		Entry("the changes separated by more than twice the context in separate hunks",
+			numberedLines(20, nil), numberedLines(20, map[int]string{3: "three", 11: "eleven"}), `--- previous
++++ current
+@@ -1,6 +1,6 @@
+ line1
+ line2
+-line3
++three
+ line4
+ line5
+ line6
+@@ -8,7 +8,7 @@
+ line8
+ line9
+ line10
+-line11
++eleven
+ line12
+ line13
+ line14
+`),
+		Entry("the changes apart from each other in separate hunks",
+			numberedLines(20, nil), numberedLines(20, map[int]string{3: "three", 13: "thirteen"}), `--- previous
++++ current
+@@ -1,6 +1,6 @@
+ line1
+ line2
+-line3
++three
+ line4
+ line5
+ line6
+@@ -10,7 +10,7 @@
+ line10
+ line11
+ line12
+-line13
++thirteen
+ line14
+ line15
+ line16
+`),
+		Entry("lines added at the end", "a\nb\n", "a\nb\nc\n", "--- previous\n+++ current\n@@ -1,2 +1,3 @@\n a\n b\n+c\n"),
+		Entry("a line added to a single line", "a\n", "b\na\n", "--- previous\n+++ current\n@@ -1 +1,2 @@\n+b\n a\n"),
+		Entry("an empty range of the created manifest", "", "a\nb\n", "--- previous\n+++ current\n@@ -0,0 +1,2 @@\n+a\n+b\n"),
+		Entry("an empty range of the removed manifest", "a\nb\n", "", "--- previous\n+++ current\n@@ -1,2 +0,0 @@\n-a\n-b\n"),
+		Entry("the missing newline at the end of the file", "a\nb", "a\nc",
+			"--- previous\n+++ current\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"),
+		Entry("the newline added at the end of the file", "a\nb", "a\nb\n",
+			"--- previous\n+++ current\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"),
+	)
+})
@@ -0,0 +1,108 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The glob patterns of the resource names and the metadata keys share the same semantics:
+// `*` matches any sequence of characters including `/`, `?` matches any single character,
+// `[...]` matches a character of the class (negated by a leading `!` or `^`),
+// and `\` escapes the following character. The pattern matches the whole string.
+
+// matchGlob reports whether s matches the glob pattern
+func matchGlob(pattern, s string) (bool, error) {
+	re, err := compileGlob(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+// matchAnyGlob reports whether s matches any of the glob patterns
+func matchAnyGlob(patterns []string, s string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := matchGlob(pattern, s)
+		if err != nil {
+			return false, err
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// compileGlob translates the glob pattern into a regular expression
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+
+	chars := []rune(pattern)
+	for i := 0; i < len(chars); i++ {
+		switch c := chars[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if i+1 == len(chars) {
+				return nil, fmt.Errorf("glob pattern %q ends with an escape", pattern)
+			}
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(chars[i])))
+		case '[':
+			end := i + 1
+			if end < len(chars) && (chars[end] == '!' || chars[end] == '^') {
+				end++
+			}
+			// a `]` right after the opening is a member of the class
+			if end < len(chars) && chars[end] == ']' {
+				end++
+			}
+			for end < len(chars) && chars[end] != ']' {
+				end++
+			}
+			if end == len(chars) {
+				return nil, fmt.Errorf("glob pattern %q has an unclosed character class", pattern)
+			}
+
+			class := chars[i+1 : end]
+			expr.WriteString("[")
+			if class[0] == '!' || class[0] == '^' {
+				expr.WriteString("^")
+				class = class[1:]
+			}
+			for _, m := range class {
+				if m == '\\' || m == '[' || m == ']' || m == '^' {
+					expr.WriteString(`\`)
+				}
+				expr.WriteRune(m)
+			}
+			expr.WriteString("]")
+			i = end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	return compileRegex(expr.String())
+}
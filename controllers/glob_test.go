@@ -0,0 +1,58 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("matchGlob", func() {
+	DescribeTable("matches the whole string",
+		func(pattern, s string, matched bool) {
+			m, err := matchGlob(pattern, s)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m).To(Equal(matched))
+		},
+		Entry("a literal", "coredns", "coredns", true),
+		Entry("a prefix of a literal", "core", "coredns", false),
+		Entry("a star", "core*", "coredns", true),
+		Entry("a star matching nothing", "coredns*", "coredns", true),
+		Entry("a star across a slash", "helm.sh/*", "helm.sh/chart", true),
+		Entry("a star across the prefix of a key", "*/role-arn", "eks.amazonaws.com/role-arn", true),
+		Entry("a question mark", "node-?", "node-1", true),
+		Entry("a question mark of a single character", "node-?", "node-10", false),
+		Entry("a character class", "node-[ab]", "node-b", true),
+		Entry("a character range", "node-[0-9]", "node-7", true),
+		Entry("a negated character class", "node-[!0-9]", "node-7", false),
+		Entry("a negated character class by a caret", "node-[^0-9]", "node-x", true),
+		Entry("a dot as a literal", "kube.proxy", "kube-proxy", false),
+		Entry("an escaped star", `core\*`, "core*", true),
+		Entry("an escaped star as a literal", `core\*`, "coredns", false),
+	)
+
+	DescribeTable("rejects the invalid patterns",
+		func(pattern string) {
+			_, err := matchGlob(pattern, "coredns")
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("an unclosed character class", "core[dns"),
+		Entry("an empty character class", "core[]"),
+		Entry("a trailing escape", `core\`),
+	)
+})
@@ -0,0 +1,188 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+// recordSnapshot keeps the captured manifest as a ManifestSnapshot owned by the Capturer,
+// unless it is the same as the latest one of the object, and prunes the snapshots out of the retention.
+// The snapshots are listed by reader, which should bypass the cache so that the sequence is never reused.
+func recordSnapshot(ctx context.Context, r client.Client, reader client.Reader, cd *captured) error {
+	c := cd.capturer
+	s := cd.snapshot
+	history, err := listSnapshots(ctx, reader, c)
+	if err != nil {
+		return err
+	}
+
+	key := manifestStoreKey(s.Object)
+	objectHistory := history[key]
+	sequence := int64(1)
+	if len(objectHistory) > 0 {
+		latest := objectHistory[0]
+		if latest.Spec.Hash == cd.hash && latest.Spec.Deleted == s.Deleted {
+			return nil
+		}
+		sequence = latest.Spec.Sequence + 1
+	}
+
+	manifest, diff, err := snapshotManifest(c, s)
+	if err != nil {
+		return err
+	}
+
+	ms := capturerv1alpha1.ManifestSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:    c.GetNamespace(),
+			GenerateName: c.GetName() + "-",
+			Labels: map[string]string{
+				manifestStoreLabel: string(c.GetUID()),
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(c, capturerv1alpha1.GroupVersion.WithKind("Capturer")),
+			},
+		},
+		Spec: capturerv1alpha1.ManifestSnapshotSpec{
+			Capturer:    c.GetName(),
+			Object:      s.Object,
+			CapturedAt:  metav1.Now(),
+			Sequence:    sequence,
+			Deleted:     s.Deleted,
+			Manifest:    manifest,
+			Hash:        cd.hash,
+			Diff:        diff,
+			Summary:     s.Summary,
+			Attribution: capturerv1alpha1.NewSnapshotAttribution(s.Attribution),
+		},
+	}
+	if err := r.Create(ctx, &ms); err != nil {
+		return err
+	}
+	history[key] = append([]capturerv1alpha1.ManifestSnapshot{ms}, objectHistory...)
+
+	return pruneSnapshots(ctx, r, c.Spec.History, history, time.Now())
+}
+
+// snapshotManifest returns the manifest and the diff to be kept in the ManifestSnapshot.
+// ManifestSnapshots are readable by anyone who can read the Capturers, so the values of a Secret
+// captured in plaintext are hashed, and its diff, which would reveal them, is omitted.
+func snapshotManifest(c *capturerv1alpha1.Capturer, s *capturerv1alpha1.Snapshot) (string, string, error) {
+	if s.Object.Kind != "Secret" || c.Spec.SecretPolicy != capturerv1alpha1.SecretPolicyPlaintext {
+		return string(s.Manifest), s.Diff, nil
+	}
+
+	manifest, err := hashSecret(s.Manifest)
+	if err != nil {
+		return "", "", err
+	}
+	return string(manifest), "", nil
+}
+
+// listSnapshots returns the ManifestSnapshots of the Capturer per object, the latest first
+func listSnapshots(ctx context.Context, r client.Reader, c *capturerv1alpha1.Capturer) (map[string][]capturerv1alpha1.ManifestSnapshot, error) {
+	var list capturerv1alpha1.ManifestSnapshotList
+	if err := r.List(ctx, &list,
+		client.InNamespace(c.GetNamespace()),
+		client.MatchingLabels{manifestStoreLabel: string(c.GetUID())},
+	); err != nil {
+		return nil, err
+	}
+
+	history := map[string][]capturerv1alpha1.ManifestSnapshot{}
+	for _, ms := range list.Items {
+		if ms.Spec.Capturer != c.GetName() {
+			continue
+		}
+		key := manifestStoreKey(ms.Spec.Object)
+		history[key] = append(history[key], ms)
+	}
+	for _, snapshots := range history {
+		sort.Slice(snapshots, func(i, j int) bool {
+			return snapshots[j].Spec.Sequence < snapshots[i].Spec.Sequence
+		})
+	}
+	return history, nil
+}
+
+// expireSnapshots prunes the snapshots of the Capturer out of the retention without any new capture,
+// and returns when to prune them again, which is zero unless the maximum age is set.
+// It is at the latest after the maximum age, since the latest snapshot of an object only starts
+// to expire once another one is captured, which is unknown to the CapturerController.
+func expireSnapshots(ctx context.Context, r client.Client, reader client.Reader, c *capturerv1alpha1.Capturer) (time.Duration, error) {
+	policy := c.Spec.History
+	if policy == nil {
+		return 0, nil
+	}
+
+	history, err := listSnapshots(ctx, reader, c)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if err = pruneSnapshots(ctx, r, policy, history, now); err != nil {
+		return 0, err
+	}
+	if policy.MaxAge == nil {
+		return 0, nil
+	}
+
+	next := policy.MaxAge.Duration
+	for _, snapshots := range history {
+		for i := 1; i < len(snapshots) && (policy.MaxCount <= 0 || i < int(policy.MaxCount)); i++ {
+			left := snapshots[i].Spec.CapturedAt.Add(policy.MaxAge.Duration).Sub(now)
+			if left > 0 && left < next {
+				next = left
+			}
+		}
+	}
+	return next, nil
+}
+
+// pruneSnapshots deletes the snapshots beyond the maximum count or older than the maximum age,
+// keeping the latest one of each object
+func pruneSnapshots(ctx context.Context, r client.Client, policy *capturerv1alpha1.HistoryPolicy, history map[string][]capturerv1alpha1.ManifestSnapshot, now time.Time) error {
+	if policy == nil {
+		return nil
+	}
+
+	errs := []error{}
+	for _, snapshots := range history {
+		for i := 1; i < len(snapshots); i++ {
+			ms := &snapshots[i]
+			expired := policy.MaxAge != nil && now.Sub(ms.Spec.CapturedAt.Time) > policy.MaxAge.Duration
+			if !expired && (policy.MaxCount <= 0 || i < int(policy.MaxCount)) {
+				continue
+			}
+
+			if err := r.Delete(ctx, ms); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
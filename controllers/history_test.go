@@ -0,0 +1,98 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("recordSnapshot", func() {
+	var (
+		ctx context.Context
+		r   client.Client
+		c   *capturerv1alpha1.Capturer
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(capturerv1alpha1.AddToScheme(scheme)).To(Succeed())
+		r = fake.NewFakeClientWithScheme(scheme)
+
+		c = &capturerv1alpha1.Capturer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "history-capturer", UID: "0c8b0f0e-1d8a-4b1e-9d5c-5f2a7e3b9c11"},
+			Spec: capturerv1alpha1.CapturerSpec{
+				NamespacedResource: true,
+				ResourceKind:       "ConfigMap",
+				ResourceName:       "coredns",
+				History:            &capturerv1alpha1.HistoryPolicy{MaxCount: 3},
+			},
+		}
+	})
+
+	record := func(kind string, manifest string) {
+		s := &capturerv1alpha1.Snapshot{
+			Object:   corev1.ObjectReference{Kind: kind, Namespace: "default", Name: "coredns"},
+			Manifest: []byte(manifest),
+			Diff:     "--- previous\n+++ current\n",
+		}
+		Expect(recordSnapshot(ctx, r, r, &captured{capturer: c, snapshot: s, hash: manifestHash(s.Manifest)})).To(Succeed())
+	}
+
+	It("orders the snapshots taken within a second by their sequence", func() {
+		for i := 1; i <= 5; i++ {
+			record("ConfigMap", fmt.Sprintf("data:\n  version: \"%d\"\n", i))
+		}
+		// the same manifest as the latest is not recorded again
+		record("ConfigMap", "data:\n  version: \"5\"\n")
+
+		history, err := listSnapshots(ctx, r, c)
+		Expect(err).NotTo(HaveOccurred())
+		snapshots := history["ConfigMap.default.coredns"]
+		Expect(snapshots).To(HaveLen(3))
+		for i, ms := range snapshots {
+			Expect(ms.Spec.Sequence).To(Equal(int64(5 - i)))
+			Expect(ms.Spec.Manifest).To(Equal(fmt.Sprintf("data:\n  version: \"%d\"\n", 5-i)))
+		}
+	})
+
+	It("keeps the values of a Secret captured in plaintext hashed", func() {
+		c.Spec.ResourceKind = "Secret"
+		c.Spec.SecretPolicy = capturerv1alpha1.SecretPolicyPlaintext
+		record("Secret", "apiVersion: v1\nkind: Secret\ndata:\n  password: c2VjcmV0\n")
+
+		history, err := listSnapshots(ctx, r, c)
+		Expect(err).NotTo(HaveOccurred())
+		ms := history["Secret.default.coredns"][0]
+		Expect(ms.Spec.Manifest).NotTo(ContainSubstring("c2VjcmV0"))
+		Expect(ms.Spec.Manifest).To(ContainSubstring("password: sha256:" + manifestHash([]byte("secret"))))
+		Expect(ms.Spec.Diff).To(BeEmpty())
+	})
+})
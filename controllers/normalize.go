@@ -0,0 +1,338 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+// wildcard is the path segment matching any key or index
+const wildcard = "*"
+
+// lastAppliedConfigAnnotation is removed from the captured manifest unless it is included explicitly
+const lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// retainMetadata copies the metadata kept by the policy from the resource to the manifest
+func retainMetadata(policy *capturerv1alpha1.MetadataPolicy, resource, manifest *unstructured.Unstructured) error {
+	if policy == nil {
+		manifest.SetLabels(resource.GetLabels())
+		return nil
+	}
+
+	labels, err := filterKeys(resource.GetLabels(), policy.Labels)
+	if err != nil {
+		return err
+	}
+	manifest.SetLabels(labels)
+
+	if policy.Annotations != nil {
+		annotations, err := filterKeys(resource.GetAnnotations(), policy.Annotations)
+		if err != nil {
+			return err
+		}
+		if !contains(policy.Annotations.Include, lastAppliedConfigAnnotation) {
+			delete(annotations, lastAppliedConfigAnnotation)
+		}
+		manifest.SetAnnotations(annotations)
+	}
+
+	if policy.KeepOwnerReferences {
+		manifest.SetOwnerReferences(resource.GetOwnerReferences())
+	}
+	if policy.KeepFinalizers {
+		manifest.SetFinalizers(resource.GetFinalizers())
+	}
+
+	return nil
+}
+
+// filterKeys returns the entries whose keys are selected by the filter
+func filterKeys(m map[string]string, filter *capturerv1alpha1.KeyFilter) (map[string]string, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+
+	filtered := make(map[string]string)
+	for k, v := range m {
+		if filter != nil {
+			if len(filter.Include) > 0 {
+				included, err := matchAnyGlob(filter.Include, k)
+				if err != nil {
+					return nil, err
+				}
+				if !included {
+					continue
+				}
+			}
+
+			excluded, err := matchAnyGlob(filter.Exclude, k)
+			if err != nil {
+				return nil, err
+			}
+			if excluded {
+				continue
+			}
+		}
+		filtered[k] = v
+	}
+
+	if len(filtered) == 0 {
+		return nil, nil
+	}
+	return filtered, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedValue replaces the values of Secrets under SecretPolicyRedact
+const redactedValue = "<redacted>"
+
+// protectSecret replaces the values in data and stringData of the Secret by the policy,
+// and removes the last applied configuration holding them unless the policy is plaintext.
+// It does nothing for the other kinds.
+func protectSecret(policy capturerv1alpha1.SecretPolicy, manifest *unstructured.Unstructured) error {
+	gvk := manifest.GroupVersionKind()
+	if gvk.Group != "" || gvk.Kind != "Secret" {
+		return nil
+	}
+
+	if policy == "" {
+		policy = capturerv1alpha1.SecretPolicyRedact
+	}
+
+	var protect func(value []byte) string
+	switch policy {
+	case capturerv1alpha1.SecretPolicyPlaintext:
+		return nil
+	case capturerv1alpha1.SecretPolicyRedact:
+		protect = func([]byte) string {
+			return redactedValue
+		}
+	case capturerv1alpha1.SecretPolicyHash:
+		protect = func(value []byte) string {
+			return "sha256:" + manifestHash(value)
+		}
+	default:
+		return fmt.Errorf("unknown secretPolicy %s", policy)
+	}
+
+	if annotations := manifest.GetAnnotations(); annotations != nil {
+		delete(annotations, lastAppliedConfigAnnotation)
+		if len(annotations) == 0 {
+			annotations = nil
+		}
+		manifest.SetAnnotations(annotations)
+	}
+
+	data, _, err := unstructured.NestedStringMap(manifest.Object, "data")
+	if err != nil {
+		return err
+	}
+	for k, v := range data {
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return err
+		}
+		data[k] = protect(decoded)
+	}
+	if len(data) > 0 {
+		if err = unstructured.SetNestedStringMap(manifest.Object, data, "data"); err != nil {
+			return err
+		}
+	}
+
+	stringData, _, err := unstructured.NestedStringMap(manifest.Object, "stringData")
+	if err != nil {
+		return err
+	}
+	for k, v := range stringData {
+		stringData[k] = protect([]byte(v))
+	}
+	if len(stringData) > 0 {
+		if err = unstructured.SetNestedStringMap(manifest.Object, stringData, "stringData"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revealsSecret reports whether the manifest of the object captured by the Capturer holds the values of a Secret
+func revealsSecret(c *capturerv1alpha1.Capturer, ref corev1.ObjectReference) bool {
+	gvk := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind)
+	return gvk.Group == "" && gvk.Kind == "Secret" && c.Spec.SecretPolicy == capturerv1alpha1.SecretPolicyPlaintext
+}
+
+// hashSecret returns the Secret manifest with its values hashed as SecretPolicyHash,
+// so that the changes of the values are told without revealing them
+func hashSecret(manifest []byte) ([]byte, error) {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(manifest, &obj.Object); err != nil {
+		return nil, err
+	}
+	if err := protectSecret(capturerv1alpha1.SecretPolicyHash, obj); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(obj.Object)
+}
+
+// removeFields removes the fields at the paths from obj
+func removeFields(obj map[string]interface{}, paths []string) error {
+	for _, p := range paths {
+		segments, err := parseFieldPath(p)
+		if err != nil {
+			return err
+		}
+		removeField(obj, segments)
+	}
+	return nil
+}
+
+// removeField removes the field at the path segments from v, and returns v
+// since removing an element from a list makes a new slice
+func removeField(v interface{}, segments []string) interface{} {
+	if len(segments) == 0 {
+		return v
+	}
+	seg, rest := segments[0], segments[1:]
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k := range t {
+			if seg != wildcard && seg != k {
+				continue
+			}
+
+			if len(rest) == 0 {
+				delete(t, k)
+			} else {
+				t[k] = removeField(t[k], rest)
+			}
+		}
+		return t
+
+	case []interface{}:
+		if len(rest) == 0 {
+			if seg == wildcard {
+				return []interface{}{}
+			}
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(t) {
+				return t
+			}
+			return append(t[:i:i], t[i+1:]...)
+		}
+
+		for i := range t {
+			if seg == wildcard || seg == strconv.Itoa(i) {
+				t[i] = removeField(t[i], rest)
+			}
+		}
+		return t
+	}
+
+	return v
+}
+
+// parseFieldPath splits a JSON pointer or a JSONPath into the segments
+func parseFieldPath(p string) ([]string, error) {
+	if strings.HasPrefix(p, "/") {
+		segments := strings.Split(p[1:], "/")
+		for i, seg := range segments {
+			segments[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(seg)
+		}
+		return segments, nil
+	}
+
+	p = strings.TrimPrefix(p, "$")
+	segments := []string{}
+	for i := 0; i < len(p); {
+		switch p[i] {
+		case '.':
+			i++
+			fallthrough
+
+		default:
+			j := i
+			for j < len(p) && p[j] != '.' && p[j] != '[' {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("invalid field path %q: empty key at %d", p, i)
+			}
+			segments = append(segments, p[i:j])
+			i = j
+
+		case '[':
+			if i+1 < len(p) && (p[i+1] == '\'' || p[i+1] == '"') {
+				quote := p[i+1]
+				end := strings.IndexByte(p[i+2:], quote)
+				if end < 0 || i+2+end+1 >= len(p) || p[i+2+end+1] != ']' {
+					return nil, fmt.Errorf("invalid field path %q: unterminated key at %d", p, i)
+				}
+				segments = append(segments, p[i+2:i+2+end])
+				i = i + 2 + end + 2
+				continue
+			}
+
+			end := strings.IndexByte(p[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid field path %q: unterminated index at %d", p, i)
+			}
+			segments = append(segments, p[i+1:i+end])
+			i += end + 1
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("invalid field path %q", p)
+	}
+	return segments, nil
+}
+
+// applyPatch applies the JSON patch written in JSON or YAML to the JSON document
+func applyPatch(doc []byte, patch string) ([]byte, error) {
+	patchJSON, err := yaml.YAMLToJSON([]byte(patch))
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := jsonpatch.DecodePatch(patchJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Apply(doc)
+}
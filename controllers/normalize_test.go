@@ -0,0 +1,430 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("parseFieldPath", func() {
+	DescribeTable("splits the path into the segments",
+		func(path string, segments []string) {
+			Expect(parseFieldPath(path)).To(Equal(segments))
+		},
+		Entry("a JSON pointer", "/spec/replicas", []string{"spec", "replicas"}),
+		Entry("a JSON pointer escaping a slash", "/metadata/annotations/example.com~1owner", []string{"metadata", "annotations", "example.com/owner"}),
+		Entry("a JSON pointer escaping a tilde", "/metadata/labels/a~0b", []string{"metadata", "labels", "a~b"}),
+		Entry("a JSON pointer unescaping a tilde before a slash", "/metadata/labels/a~01", []string{"metadata", "labels", "a~1"}),
+		Entry("a JSON pointer with an index", "/spec/containers/0/image", []string{"spec", "containers", "0", "image"}),
+		Entry("a JSONPath", ".spec.replicas", []string{"spec", "replicas"}),
+		Entry("a JSONPath from the root", "$.spec.replicas", []string{"spec", "replicas"}),
+		Entry("a JSONPath without the leading dot", "spec.replicas", []string{"spec", "replicas"}),
+		Entry("a JSONPath quoting a key with dots and a slash",
+			".spec.template.metadata.annotations['kubectl.kubernetes.io/restartedAt']",
+			[]string{"spec", "template", "metadata", "annotations", "kubectl.kubernetes.io/restartedAt"}),
+		Entry("a JSONPath quoting a key with double quotes", `.metadata.labels["app.kubernetes.io/name"]`, []string{"metadata", "labels", "app.kubernetes.io/name"}),
+		Entry("a JSONPath quoting a key with brackets", `.data['a]b']`, []string{"data", "a]b"}),
+		Entry("a JSONPath with an index", ".spec.containers[0].image", []string{"spec", "containers", "0", "image"}),
+		Entry("a JSONPath with a wildcard", ".spec.containers[*].image", []string{"spec", "containers", "*", "image"}),
+		Entry("a JSONPath with a wildcard key", ".metadata.annotations.*", []string{"metadata", "annotations", "*"}),
+	)
+
+	DescribeTable("rejects the invalid path",
+		func(path string) {
+			_, err := parseFieldPath(path)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("an empty path", ""),
+		Entry("the root only", "$"),
+		Entry("an empty key", ".spec..replicas"),
+		Entry("a trailing dot", ".spec."),
+		Entry("an unterminated quoted key", ".metadata.annotations['foo"),
+		Entry("a quoted key without the bracket", ".metadata.annotations['foo'"),
+		Entry("an unterminated index", ".spec.containers[0"),
+	)
+})
+
+var _ = Describe("removeFields", func() {
+	const manifest = `
+metadata:
+  annotations:
+    example.com/owner: alice
+    kubectl.kubernetes.io/restartedAt: "2021-03-01T12:34:56Z"
+  labels:
+    a~b: c
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+        env:
+        - name: A
+        - name: B
+      - name: sidecar
+        image: sidecar:v1
+`
+
+	DescribeTable("removes the fields at the paths",
+		func(paths []string, expected string) {
+			var obj map[string]interface{}
+			Expect(yaml.Unmarshal([]byte(manifest), &obj)).To(Succeed())
+			Expect(removeFields(obj, paths)).To(Succeed())
+
+			var want map[string]interface{}
+			Expect(yaml.Unmarshal([]byte(expected), &want)).To(Succeed())
+			Expect(obj).To(Equal(want))
+		},
+		Entry("a field",
+			[]string{"/spec/replicas"}, `
+metadata:
+  annotations:
+    example.com/owner: alice
+    kubectl.kubernetes.io/restartedAt: "2021-03-01T12:34:56Z"
+  labels:
+    a~b: c
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+        env:
+        - name: A
+        - name: B
+      - name: sidecar
+        image: sidecar:v1
+`),
+		Entry("keys escaped in a JSON pointer and quoted in a JSONPath",
+			[]string{"/metadata/annotations/example.com~1owner", "/metadata/labels/a~0b", ".metadata.annotations['kubectl.kubernetes.io/restartedAt']"}, `
+metadata:
+  annotations: {}
+  labels: {}
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+        env:
+        - name: A
+        - name: B
+      - name: sidecar
+        image: sidecar:v1
+`),
+		Entry("a field of every element of a list",
+			[]string{".spec.template.spec.containers[*].image"}, `
+metadata:
+  annotations:
+    example.com/owner: alice
+    kubectl.kubernetes.io/restartedAt: "2021-03-01T12:34:56Z"
+  labels:
+    a~b: c
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        env:
+        - name: A
+        - name: B
+      - name: sidecar
+`),
+		Entry("an element of a nested list",
+			[]string{"/spec/template/spec/containers/0/env/0"}, `
+metadata:
+  annotations:
+    example.com/owner: alice
+    kubectl.kubernetes.io/restartedAt: "2021-03-01T12:34:56Z"
+  labels:
+    a~b: c
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+        env:
+        - name: B
+      - name: sidecar
+        image: sidecar:v1
+`),
+		Entry("all the elements of a list",
+			[]string{".spec.template.spec.containers[0].env[*]"}, `
+metadata:
+  annotations:
+    example.com/owner: alice
+    kubectl.kubernetes.io/restartedAt: "2021-03-01T12:34:56Z"
+  labels:
+    a~b: c
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+        env: []
+      - name: sidecar
+        image: sidecar:v1
+`),
+		Entry("all the keys of a map",
+			[]string{".metadata.annotations.*"}, `
+metadata:
+  annotations: {}
+  labels:
+    a~b: c
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+        env:
+        - name: A
+        - name: B
+      - name: sidecar
+        image: sidecar:v1
+`),
+		Entry("nothing for the missing paths and the indexes out of range",
+			[]string{"/spec/paused", "/metadata/annotations/missing", "/spec/template/spec/containers/2", "/spec/template/spec/containers/-1", "/spec/replicas/0"}, manifest),
+	)
+
+	It("fails on the invalid path", func() {
+		obj := map[string]interface{}{"spec": map[string]interface{}{}}
+		Expect(removeFields(obj, []string{".spec..replicas"})).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("retainMetadata", func() {
+	const resource = `
+metadata:
+  name: coredns
+  namespace: kube-system
+  labels:
+    app: coredns
+    app.kubernetes.io/managed-by: helm
+    helm.sh/chart: coredns-1.0.0
+  annotations:
+    example.com/owner: alice
+    helm.sh/hook: pre-install
+    kubectl.kubernetes.io/last-applied-configuration: '{}'
+  ownerReferences:
+  - apiVersion: apps/v1
+    kind: ReplicaSet
+    name: coredns-5d78c9869d
+    uid: 0c8b0f0e-1d8a-4b1e-9d5c-5f2a7e3b9c12
+  finalizers:
+  - example.com/cleanup
+`
+
+	DescribeTable("keeps the metadata selected by the policy",
+		func(policy *capturerv1alpha1.MetadataPolicy, expected string) {
+			obj := &unstructured.Unstructured{}
+			Expect(yaml.Unmarshal([]byte(resource), &obj.Object)).To(Succeed())
+			manifest := &unstructured.Unstructured{Object: map[string]interface{}{}}
+			manifest.SetName(obj.GetName())
+			manifest.SetNamespace(obj.GetNamespace())
+
+			Expect(retainMetadata(policy, obj, manifest)).To(Succeed())
+			actual, err := yaml.Marshal(manifest.Object)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(actual).To(MatchYAML(expected))
+		},
+		Entry("only the labels without the policy", nil, `
+metadata:
+  name: coredns
+  namespace: kube-system
+  labels:
+    app: coredns
+    app.kubernetes.io/managed-by: helm
+    helm.sh/chart: coredns-1.0.0
+`),
+		Entry("all the labels and no annotations with the empty policy", &capturerv1alpha1.MetadataPolicy{}, `
+metadata:
+  name: coredns
+  namespace: kube-system
+  labels:
+    app: coredns
+    app.kubernetes.io/managed-by: helm
+    helm.sh/chart: coredns-1.0.0
+`),
+		Entry("the keys included and not excluded",
+			&capturerv1alpha1.MetadataPolicy{
+				Labels:      &capturerv1alpha1.KeyFilter{Include: []string{"app*", "helm.sh/*"}, Exclude: []string{"*/managed-by"}},
+				Annotations: &capturerv1alpha1.KeyFilter{Exclude: []string{"helm.sh/*"}},
+			}, `
+metadata:
+  name: coredns
+  namespace: kube-system
+  labels:
+    app: coredns
+    helm.sh/chart: coredns-1.0.0
+  annotations:
+    example.com/owner: alice
+`),
+		Entry("the exclusion over the inclusion",
+			&capturerv1alpha1.MetadataPolicy{
+				Labels: &capturerv1alpha1.KeyFilter{Include: []string{"app"}, Exclude: []string{"app"}},
+			}, `
+metadata:
+  name: coredns
+  namespace: kube-system
+`),
+		Entry("the last applied configuration included by a pattern removed",
+			&capturerv1alpha1.MetadataPolicy{
+				Annotations: &capturerv1alpha1.KeyFilter{Include: []string{"*"}},
+			}, `
+metadata:
+  name: coredns
+  namespace: kube-system
+  labels:
+    app: coredns
+    app.kubernetes.io/managed-by: helm
+    helm.sh/chart: coredns-1.0.0
+  annotations:
+    example.com/owner: alice
+    helm.sh/hook: pre-install
+`),
+		Entry("the last applied configuration included explicitly",
+			&capturerv1alpha1.MetadataPolicy{
+				Labels:      &capturerv1alpha1.KeyFilter{Include: []string{"none"}},
+				Annotations: &capturerv1alpha1.KeyFilter{Include: []string{lastAppliedConfigAnnotation}},
+			}, `
+metadata:
+  name: coredns
+  namespace: kube-system
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{}'
+`),
+		Entry("the ownerReferences and the finalizers",
+			&capturerv1alpha1.MetadataPolicy{
+				Labels:              &capturerv1alpha1.KeyFilter{Exclude: []string{"*"}},
+				KeepOwnerReferences: true,
+				KeepFinalizers:      true,
+			}, `
+metadata:
+  name: coredns
+  namespace: kube-system
+  ownerReferences:
+  - apiVersion: apps/v1
+    kind: ReplicaSet
+    name: coredns-5d78c9869d
+    uid: 0c8b0f0e-1d8a-4b1e-9d5c-5f2a7e3b9c12
+  finalizers:
+  - example.com/cleanup
+`),
+	)
+
+	It("fails on the invalid pattern", func() {
+		obj := &unstructured.Unstructured{}
+		Expect(yaml.Unmarshal([]byte(resource), &obj.Object)).To(Succeed())
+		policy := &capturerv1alpha1.MetadataPolicy{Labels: &capturerv1alpha1.KeyFilter{Include: []string{"app["}}}
+		Expect(retainMetadata(policy, obj, &unstructured.Unstructured{Object: map[string]interface{}{}})).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("protectSecret", func() {
+	// the values of the Secret are `secret` in data and `admin` in stringData
+	const secret = `apiVersion: v1
+kind: Secret
+metadata:
+  name: credentials
+  namespace: default
+  annotations:
+    example.com/owner: alice
+    kubectl.kubernetes.io/last-applied-configuration: '{"apiVersion":"v1","kind":"Secret","data":{"password":"c2VjcmV0"},"stringData":{"username":"admin"}}'
+type: Opaque
+data:
+  password: c2VjcmV0
+stringData:
+  username: admin
+`
+
+	// extract extracts the manifest of the object keeping all the annotations by the policy
+	extract := func(manifest string, policy capturerv1alpha1.SecretPolicy) ([]byte, error) {
+		obj := &unstructured.Unstructured{}
+		Expect(yaml.Unmarshal([]byte(manifest), &obj.Object)).To(Succeed())
+
+		c := &capturerv1alpha1.Capturer{}
+		c.Spec.SecretPolicy = policy
+		c.Spec.Metadata = &capturerv1alpha1.MetadataPolicy{
+			Annotations: &capturerv1alpha1.KeyFilter{Include: []string{"*", lastAppliedConfigAnnotation}},
+		}
+		return extractManifest(c, obj)
+	}
+
+	DescribeTable("replaces the values by the policy without leaking them",
+		func(policy capturerv1alpha1.SecretPolicy, password, username string) {
+			manifest, err := extract(secret, policy)
+			Expect(err).NotTo(HaveOccurred())
+
+			var doc map[string]interface{}
+			Expect(yaml.Unmarshal(manifest, &doc)).To(Succeed())
+			Expect(doc["data"]).To(Equal(map[string]interface{}{"password": password}))
+			Expect(doc["stringData"]).To(Equal(map[string]interface{}{"username": username}))
+			Expect(doc["type"]).To(Equal("Opaque"))
+			Expect(doc["metadata"].(map[string]interface{})["annotations"]).To(Equal(map[string]interface{}{"example.com/owner": "alice"}))
+
+			for _, value := range []string{"c2VjcmV0", "secret", "admin"} {
+				Expect(string(manifest)).NotTo(ContainSubstring(value))
+			}
+		},
+		Entry("redact by default", capturerv1alpha1.SecretPolicy(""), redactedValue, redactedValue),
+		Entry("redact", capturerv1alpha1.SecretPolicyRedact, redactedValue, redactedValue),
+		Entry("hash of the decoded values", capturerv1alpha1.SecretPolicyHash,
+			"sha256:"+manifestHash([]byte("secret")), "sha256:"+manifestHash([]byte("admin"))),
+	)
+
+	It("keeps the values and the last applied configuration in plaintext", func() {
+		manifest, err := extract(secret, capturerv1alpha1.SecretPolicyPlaintext)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(manifest)).To(ContainSubstring("password: c2VjcmV0"))
+		Expect(string(manifest)).To(ContainSubstring("username: admin"))
+		Expect(string(manifest)).To(ContainSubstring(lastAppliedConfigAnnotation))
+	})
+
+	DescribeTable("leaves the other kinds as they are",
+		func(manifest string) {
+			extracted, err := extract(manifest, capturerv1alpha1.SecretPolicyRedact)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(extracted)).To(ContainSubstring("password: c2VjcmV0"))
+		},
+		Entry("a ConfigMap", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\ndata:\n  password: c2VjcmV0\n"),
+		Entry("a Secret of another group", "apiVersion: example.com/v1\nkind: Secret\nmetadata:\n  name: config\ndata:\n  password: c2VjcmV0\n"),
+	)
+
+	DescribeTable("fails rather than publishing the values",
+		func(manifest string, policy capturerv1alpha1.SecretPolicy) {
+			_, err := extract(manifest, policy)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("an unknown policy", secret, capturerv1alpha1.SecretPolicy("base64")),
+		Entry("a value not encoded in base64", "apiVersion: v1\nkind: Secret\nmetadata:\n  name: credentials\ndata:\n  password: not base64!\n", capturerv1alpha1.SecretPolicyHash),
+	)
+})
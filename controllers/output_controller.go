@@ -18,13 +18,19 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
 )
@@ -42,6 +48,7 @@ type OutputController struct {
 // +kubebuilder:rbac:groups=capturer.stable.example.com,resources=outputs/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=capturer,resources=outputs,verbs=get;list;watch
 // +kubebuilder:rbac:groups=capturer,resources=outputs/status,verbs=get
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 
 func (r *OutputController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -56,20 +63,57 @@ func (r *OutputController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
-	if err := o.GetPublisher().Setup(); err != nil {
-		log.Error(err, "failed to setup Output")
-		return ctrl.Result{}, err
+	var setupErr error
+	if p := o.GetPublisher(); p == nil {
+		setupErr = &capturerv1alpha1.OutputError{
+			Reason: capturerv1alpha1.ReasonInvalidSpec,
+			Err:    fmt.Errorf("output has no destination"),
+		}
+	} else {
+		var creds *capturerv1alpha1.GitCredentials
+		if creds, setupErr = resolveCredentials(ctx, r, &o); setupErr == nil {
+			setupErr = p.Setup(creds)
+		}
+	}
+
+	if err := updateOutputStatus(ctx, r, req.NamespacedName, func(status *capturerv1alpha1.OutputStatus) {
+		setOutputSetupStatus(status, setupErr)
+	}); err != nil {
+		log.Error(err, "failed to update Output status")
+	}
+
+	if setupErr != nil {
+		log.Error(setupErr, "failed to setup Output")
+		return ctrl.Result{}, setupErr
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// SetupWithManager also watches the Secrets other than the manifest stores, so that the Outputs are set up again
+// when the credentials they refer are created, rotated or deleted
 func (r *OutputController) SetupWithManager(mgr ctrl.Manager) error {
-	haveGeneration := false
+	haveGeneration := true
 	return ctrl.NewControllerManagedBy(mgr).
 		For(
 			&capturerv1alpha1.Output{},
 			builder.WithPredicates(Predicates(haveGeneration)),
 		).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.outputsForSecret)},
+			builder.WithPredicates(CredentialsPredicates),
+		).
 		Complete(r)
 }
+
+// outputsForSecret maps the Secret to the Outputs referring it
+func (r *OutputController) outputsForSecret(obj handler.MapObject) []reconcile.Request {
+	secret := types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: obj.Meta.GetName()}
+	requests, err := outputsReferring(context.Background(), r, secret)
+	if err != nil {
+		r.Log.Error(err, "failed to list Outputs referring Secret", "secret", secret)
+		return nil
+	}
+	return requests
+}
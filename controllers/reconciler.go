@@ -20,13 +20,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -35,6 +43,8 @@ import (
 	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
 )
 
+var captureLog = ctrl.Log.WithName("controllers").WithName("capture")
+
 var Predicates func(bool) predicate.Predicate = func(haveGeneration bool) predicate.Predicate {
 	if haveGeneration {
 		return predicate.Funcs{
@@ -69,33 +79,247 @@ var Predicates func(bool) predicate.Predicate = func(haveGeneration bool) predic
 	}
 }
 
-func capture(ctx context.Context, r client.Client, resourceKind string, obj metav1.Object) (bool, error) {
-	retry := false
+// ResourcePredicates filters the events of captured resources. Since the kind is
+// only known at runtime, kinds without metadata.generation pass on every update.
+var ResourcePredicates predicate.Predicate = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return true
+	},
+	DeleteFunc: func(e event.DeleteEvent) bool {
+		return true
+	},
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if e.MetaNew.GetGeneration() == 0 {
+			return true
+		}
+		return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration()
+	},
+	GenericFunc: func(e event.GenericEvent) bool {
+		return false
+	},
+}
+
+// captured is a snapshot of an object taken by a Capturer
+type captured struct {
+	capturer *capturerv1alpha1.Capturer
+	snapshot *capturerv1alpha1.Snapshot
+
+	// hash is the hash of the manifest in the snapshot
+	hash string
 
-	c, err := findCapturer(ctx, r, resourceKind, obj)
+	// record is the state of the object kept by the Capturer before the capture
+	record *manifestRecord
+}
+
+// capture captures obj by the Capturers targeting it.
+// It returns a positive wait instead of capturing while the audit event of the change is awaited.
+func capture(ctx context.Context, r client.Client, reader client.Reader, audit *AuditReceiver, gvk schema.GroupVersionKind, obj *unstructured.Unstructured, deleted bool) (time.Duration, bool, error) {
+	caps, err := findCapturers(ctx, r, gvk, obj)
 	if err != nil {
-		retry = true
-		return retry, err
+		return 0, true, err
+	}
+	if len(caps) == 0 {
+		return 0, false, nil
+	}
+
+	var attribution *capturerv1alpha1.Attribution
+	if !deleted {
+		attribution = attributeChange(obj)
 	}
-	if c == nil {
+	if audit != nil {
+		var wait time.Duration
+		if attribution, wait = audit.Attribute(gvk, objectReference(obj), deleted, attribution); wait > 0 {
+			return wait, false, nil
+		}
+	}
+
+	retry, err := captureBy(ctx, r, reader, caps, gvk, obj, attribution, deleted)
+	return 0, retry, err
+}
+
+// captureBy captures obj by the Capturers and publishes the manifests with the attribution.
+// The manifest stores are read by reader, which should bypass the cache.
+func captureBy(ctx context.Context, r client.Client, reader client.Reader, caps []capturerv1alpha1.Capturer, gvk schema.GroupVersionKind, obj *unstructured.Unstructured, attribution *capturerv1alpha1.Attribution, deleted bool) (bool, error) {
+	retry := false
+	if len(caps) == 0 {
 		return retry, nil
 	}
 
-	manifest, err := extractManifest(resourceKind, obj)
-	if err != nil {
-		retry = true
-		return retry, err
+	// the captures of an object are serialized, so that the same manifest is published only once
+	// even when the Capturer captures its targets while the object is changed
+	defer lockObject(gvk, obj)()
+
+	var err error
+	errs := []error{}
+	captures := []*captured{}
+	for i := range caps {
+		c := &caps[i]
+		manifest, err := extractManifest(c, obj)
+		if err != nil {
+			// the manifest cannot be extracted until the Capturer is fixed
+			captureLog.Error(err, "failed to extract manifest", "capturer", c.GetName(), "object", obj.GetName())
+			if serr := updateCapturerStatus(ctx, r, c, func(latest *capturerv1alpha1.Capturer) {
+				setExtractFailedStatus(&latest.Status, err)
+			}); serr != nil {
+				errs = append(errs, serr)
+			}
+			continue
+		}
+
+		snapshot := &capturerv1alpha1.Snapshot{
+			Object:      objectReference(obj),
+			Manifest:    manifest,
+			Deleted:     deleted,
+			Attribution: attribution,
+		}
+		if c.Spec.ValidateRestorable && !deleted {
+			snapshot.Restorability = validateRestorable(ctx, r, manifest)
+		}
+
+		record, err := loadRecord(ctx, reader, c, snapshot.Object)
+		if err != nil {
+			retry = true
+			errs = append(errs, err)
+			continue
+		}
+		if previous := record.manifest; previous != nil && !deleted {
+			if err = describeChanges(c, obj, snapshot, previous); err != nil {
+				// the diff and the summary are informative, so the capture goes on without them
+				captureLog.Error(err, "failed to describe changes", "capturer", c.GetName(), "object", obj.GetName())
+			}
+		}
+
+		captures = append(captures, &captured{
+			capturer: c,
+			snapshot: snapshot,
+			hash:     manifestHash(manifest),
+			record:   record,
+		})
+	}
+
+	// the history is recorded before publishing so that it is kept even when the Outputs are unreachable
+	for _, cd := range captures {
+		if cd.capturer.Spec.History == nil {
+			continue
+		}
+		if err = recordSnapshot(ctx, r, reader, cd); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	results, publishErr := publish(ctx, r, captures)
+
+	for _, cd := range captures {
+		if err = storeRecord(ctx, r, reader, cd, results); err != nil {
+			errs = append(errs, err)
+		}
+
+		if allSkipped(cd, results) {
+			continue
+		}
+
+		count, err := countManifestStores(ctx, reader, cd.capturer)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if err = updateCapturerStatus(ctx, r, cd.capturer, func(latest *capturerv1alpha1.Capturer) {
+			setCaptureStatus(latest, cd, results)
+			latest.Status.CapturingCount = count
+		}); err != nil {
+			errs = append(errs, err)
+		}
+
 	}
 
-	if err = publish(ctx, r, c, manifest); err != nil {
+	if publishErr != nil {
 		retry = true
-		return retry, err
+		return retry, publishErr
 	}
 
-	return retry, nil
+	return retry, utilerrors.NewAggregate(errs)
 }
 
-func findCapturer(ctx context.Context, r client.Client, resourceKind string, obj metav1.Object) (*capturerv1alpha1.Capturer, error) {
+// withCapturer replaces the Capturer of the same name in caps with c, or adds c if none
+func withCapturer(caps []capturerv1alpha1.Capturer, c *capturerv1alpha1.Capturer) []capturerv1alpha1.Capturer {
+	for i := range caps {
+		if caps[i].GetNamespace() == c.GetNamespace() && caps[i].GetName() == c.GetName() {
+			caps[i] = *c
+			return caps
+		}
+	}
+	return append(caps, *c)
+}
+
+// describeChanges sets the diff and the summary of the changes from the previous manifest to the snapshot.
+// They are published to the Outputs which never encrypt them, such as Slack and the pull requests,
+// so the values of a Secret captured in plaintext are compared hashed.
+func describeChanges(c *capturerv1alpha1.Capturer, obj *unstructured.Unstructured, snapshot *capturerv1alpha1.Snapshot, previous []byte) error {
+	current := snapshot.Manifest
+	if revealsSecret(c, objectReference(obj)) {
+		var err error
+		if previous, err = hashSecret(previous); err != nil {
+			return err
+		}
+		if current, err = hashSecret(current); err != nil {
+			return err
+		}
+	}
+
+	snapshot.Diff = unifiedDiff(previous, current)
+	summary, err := summarizeChanges(previous, current)
+	if err != nil {
+		return err
+	}
+	snapshot.Summary = summary
+	return nil
+}
+
+// snapshotTargets captures all the objects currently targeted by the Capturer
+func snapshotTargets(ctx context.Context, r client.Client, reader client.Reader, c *capturerv1alpha1.Capturer) error {
+	gvk, err := c.ResourceGroupVersionKind()
+	if err != nil {
+		return err
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	opts := []client.ListOption{}
+	if c.Spec.NamespacedResource && c.Spec.ResourceNamespace != "" {
+		opts = append(opts, client.InNamespace(c.Spec.ResourceNamespace))
+	}
+	if err = r.List(ctx, list, opts...); err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for i := range list.Items {
+		obj := &list.Items[i]
+		matched, err := matchCapturer(ctx, r, c, obj)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		// the object is captured by all the Capturers targeting it as on its changes,
+		// so that the Capturers publishing it to the same Output are checked together
+		caps, err := findCapturers(ctx, r, gvk, obj)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err = captureBy(ctx, r, reader, withCapturer(caps, c), gvk, obj, attributeChange(obj), false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// findCapturers returns all the Capturers targeting obj
+func findCapturers(ctx context.Context, r client.Client, gvk schema.GroupVersionKind, obj metav1.Object) ([]capturerv1alpha1.Capturer, error) {
 	caps := capturerv1alpha1.CapturerList{}
 	if err := r.List(
 		ctx,
@@ -104,170 +328,441 @@ func findCapturer(ctx context.Context, r client.Client, resourceKind string, obj
 		return nil, err
 	}
 
-	for _, c := range caps.Items {
+	matchedCaps := []capturerv1alpha1.Capturer{}
+	for i := range caps.Items {
+		c := caps.Items[i]
 		if _, err := json.Marshal(c); err != nil {
 			return nil, err
 		}
 
-		if c.Spec.NamespacedResource {
-			if c.Spec.ResourceKind == resourceKind &&
-				c.Spec.ResourceNamespace == obj.GetNamespace() &&
-				c.Spec.ResourceName == obj.GetName() {
-				return &c, nil
-			}
-		} else {
-			if c.Spec.ResourceKind == resourceKind &&
-				c.Spec.ResourceName == obj.GetName() {
-				return &c, nil
-			}
+		cgvk, err := c.ResourceGroupVersionKind()
+		if err != nil || cgvk != gvk {
+			continue
+		}
+
+		// the invalid spec is reported by CapturerController, and must not stop the other Capturers
+		if err = validateSpec(&c); err != nil {
+			captureLog.V(1).Info("skipping invalid Capturer", "capturer", c.GetName(), "reason", err.Error())
+			continue
+		}
+
+		matched, err := matchCapturer(ctx, r, &c, obj)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			matchedCaps = append(matchedCaps, c)
 		}
 	}
 
-	return nil, nil
+	return matchedCaps, nil
 }
 
-func extractManifest(resourceKind string, resource interface{}) ([]byte, error) {
-	switch resourceKind {
-	case "ClusterRole":
-		if v, ok := resource.(*rbacv1.ClusterRole); ok {
-			vc := v.DeepCopy()
-			vc.ObjectMeta.Reset()
-			vc.SetName(v.GetName())
-			vc.SetNamespace(v.GetNamespace())
-			vc.SetLabels(v.GetLabels())
-
-			manifest, err := yaml.Marshal(vc)
-			if err != nil {
-				return []byte{}, err
-			}
-			return manifest, nil
+// matchCapturer reports whether obj is targeted by the Capturer's name, label selector
+// and namespace selector
+func matchCapturer(ctx context.Context, r client.Client, c *capturerv1alpha1.Capturer, obj metav1.Object) (bool, error) {
+	hasName := c.Spec.ResourceName != "" ||
+		len(c.Spec.ResourceNamePatterns) > 0 ||
+		c.Spec.ResourceNameRegex != ""
+	if !hasName && c.Spec.Selector == nil {
+		return false, nil
+	}
+	if controlledByCapturer(obj) {
+		return false, nil
+	}
+
+	if hasName {
+		matched, err := matchName(c, obj.GetName())
+		if err != nil {
+			return false, err
+		}
+		if !matched {
+			return false, nil
 		}
+	}
 
-	case "ClusterRoleBinding":
-		if v, ok := resource.(*rbacv1.ClusterRoleBinding); ok {
-			vc := v.DeepCopy()
-			vc.ObjectMeta.Reset()
-			vc.SetName(v.GetName())
-			vc.SetNamespace(v.GetNamespace())
-			vc.SetLabels(v.GetLabels())
+	excluded, err := matchAnyGlob(c.Spec.Exclude, obj.GetName())
+	if err != nil {
+		return false, err
+	}
+	if excluded {
+		return false, nil
+	}
 
-			manifest, err := yaml.Marshal(vc)
-			if err != nil {
-				return []byte{}, err
-			}
-			return manifest, nil
+	if c.Spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(c.Spec.Selector)
+		if err != nil {
+			return false, err
+		}
+		if !selector.Matches(labels.Set(obj.GetLabels())) {
+			return false, nil
 		}
+	}
 
-	case "ConfigMap":
-		if v, ok := resource.(*corev1.ConfigMap); ok {
-			vc := v.DeepCopy()
-			vc.ObjectMeta.Reset()
-			vc.SetName(v.GetName())
-			vc.SetNamespace(v.GetNamespace())
-			vc.SetLabels(v.GetLabels())
+	if !c.Spec.NamespacedResource {
+		return true, nil
+	}
 
-			manifest, err := yaml.Marshal(vc)
-			if err != nil {
-				return []byte{}, err
-			}
-			return manifest, nil
+	if c.Spec.ResourceNamespace != "" && c.Spec.ResourceNamespace != obj.GetNamespace() {
+		return false, nil
+	}
+
+	if c.Spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(c.Spec.NamespaceSelector)
+		if err != nil {
+			return false, err
 		}
 
-	case "Deployment":
-		if v, ok := resource.(*appsv1.Deployment); ok {
-			vc := v.DeepCopy()
-			vc.ObjectMeta.Reset()
-			vc.Status.Reset()
-			vc.SetName(v.GetName())
-			vc.SetNamespace(v.GetNamespace())
-			vc.SetLabels(v.GetLabels())
+		var ns corev1.Namespace
+		if err := r.Get(ctx, types.NamespacedName{Name: obj.GetNamespace()}, &ns); err != nil {
+			return false, err
+		}
+		if !selector.Matches(labels.Set(ns.GetLabels())) {
+			return false, nil
+		}
+	}
 
-			manifest, err := yaml.Marshal(vc)
-			if err != nil {
-				return []byte{}, err
-			}
-			return manifest, nil
+	return true, nil
+}
+
+// validateMatch checks the name patterns and the selectors of the Capturer,
+// which would otherwise fail every match
+func validateMatch(c *capturerv1alpha1.Capturer) error {
+	for _, pattern := range c.Spec.ResourceNamePatterns {
+		if _, err := compileGlob(pattern); err != nil {
+			return fmt.Errorf("invalid resourceNamePatterns %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range c.Spec.Exclude {
+		if _, err := compileGlob(pattern); err != nil {
+			return fmt.Errorf("invalid exclude %q: %w", pattern, err)
 		}
+	}
+	if c.Spec.ResourceNameRegex != "" {
+		if _, err := compileRegex(c.Spec.ResourceNameRegex); err != nil {
+			return fmt.Errorf("invalid resourceNameRegex: %w", err)
+		}
+	}
+	if c.Spec.Selector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(c.Spec.Selector); err != nil {
+			return fmt.Errorf("invalid selector: %w", err)
+		}
+	}
+	if c.Spec.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(c.Spec.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespaceSelector: %w", err)
+		}
+	}
+	return nil
+}
 
-	case "Secret":
-		if v, ok := resource.(*corev1.Secret); ok {
-			vc := v.DeepCopy()
-			vc.ObjectMeta.Reset()
-			vc.SetName(v.GetName())
-			vc.SetNamespace(v.GetNamespace())
-			vc.SetLabels(v.GetLabels())
+// matchName reports whether name satisfies any of the exact name, glob patterns
+// and regular expression of the Capturer
+func matchName(c *capturerv1alpha1.Capturer, name string) (bool, error) {
+	if c.Spec.ResourceName == name {
+		return true, nil
+	}
 
-			manifest, err := yaml.Marshal(vc)
-			if err != nil {
-				return []byte{}, err
-			}
-			return manifest, nil
+	matched, err := matchAnyGlob(c.Spec.ResourceNamePatterns, name)
+	if err != nil || matched {
+		return matched, err
+	}
+
+	if c.Spec.ResourceNameRegex != "" {
+		re, err := compileRegex(c.Spec.ResourceNameRegex)
+		if err != nil {
+			return false, err
 		}
+		if re.MatchString(name) {
+			return true, nil
+		}
+	}
 
-	case "Service":
-		if v, ok := resource.(*corev1.Service); ok {
-			vc := v.DeepCopy()
-			vc.ObjectMeta.Reset()
-			vc.SetName(v.GetName())
-			vc.SetNamespace(v.GetNamespace())
-			vc.SetLabels(v.GetLabels())
+	return false, nil
+}
 
-			manifest, err := yaml.Marshal(vc)
-			if err != nil {
-				return []byte{}, err
-			}
-			return manifest, nil
+// regexCache keeps the compiled regular expressions of the Capturers by their patterns,
+// since they are matched on every event
+var regexCache sync.Map
+
+// compileRegex returns the compiled regular expression, compiling it only on the first use
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// objectLocks holds a mutex per captured object
+var objectLocks sync.Map
+
+// lockObject locks the captures of the object, and returns the function to unlock them
+func lockObject(gvk schema.GroupVersionKind, obj metav1.Object) func() {
+	key := fmt.Sprintf("%s/%s/%s", gvk, obj.GetNamespace(), obj.GetName())
+	mu, _ := objectLocks.LoadOrStore(key, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// objectReference returns the reference to the captured object
+func objectReference(obj *unstructured.Unstructured) corev1.ObjectReference {
+	return corev1.ObjectReference{
+		APIVersion:      obj.GetAPIVersion(),
+		Kind:            obj.GetKind(),
+		Namespace:       obj.GetNamespace(),
+		Name:            obj.GetName(),
+		UID:             obj.GetUID(),
+		ResourceVersion: obj.GetResourceVersion(),
+	}
+}
+
+// extractManifest strips the cluster-managed parts of the resource, that is
+// status and ObjectMeta other than name, namespace and the metadata kept by the Capturer.
+// Then the fields ignored by the Capturer are removed and its patch is applied.
+func extractManifest(c *capturerv1alpha1.Capturer, resource *unstructured.Unstructured) ([]byte, error) {
+	vc := resource.DeepCopy()
+	unstructured.RemoveNestedField(vc.Object, "metadata")
+	unstructured.RemoveNestedField(vc.Object, "status")
+	vc.SetName(resource.GetName())
+	vc.SetNamespace(resource.GetNamespace())
+	if err := retainMetadata(c.Spec.Metadata, resource, vc); err != nil {
+		return []byte{}, err
+	}
+	if err := protectSecret(c.Spec.SecretPolicy, vc); err != nil {
+		return []byte{}, err
+	}
+
+	if err := removeFields(vc.Object, c.Spec.IgnoreFields); err != nil {
+		return []byte{}, err
+	}
+
+	doc, err := json.Marshal(vc.Object)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	if c.Spec.Patch != "" {
+		if doc, err = applyPatch(doc, c.Spec.Patch); err != nil {
+			return []byte{}, err
 		}
+	}
+
+	manifest, err := yaml.JSONToYAML(doc)
+	if err != nil {
+		return []byte{}, err
+	}
+	return manifest, nil
+}
+
+// publishKey identifies a publication, which is a manifest published to an Output
+type publishKey struct {
+	output types.NamespacedName
+	hash   string
+}
+
+// publishResult is the result of publishing a snapshot to an Output
+type publishResult struct {
+	err error
 
-	case "ServiceAccount":
-		if v, ok := resource.(*corev1.ServiceAccount); ok {
-			vc := v.DeepCopy()
-			vc.ObjectMeta.Reset()
-			vc.SetName(v.GetName())
-			vc.SetNamespace(v.GetNamespace())
-			vc.SetLabels(v.GetLabels())
+	// url is where the snapshot is published for review, e.g. the pull request
+	url string
 
-			manifest, err := yaml.Marshal(vc)
-			if err != nil {
-				return []byte{}, err
+	// skipped reports the manifest was not published since it has not changed
+	// from the last publication
+	skipped bool
+}
+
+// publish publishes the snapshots to the Outputs of their Capturers, and returns
+// the result per publication. The same manifest is published to an Output only once
+// even if the Output is referred by several Capturers, and is skipped if it has
+// already been published through all of them. The different manifests of the object
+// are published to an Output by none of the Capturers since they would overwrite each other.
+func publish(ctx context.Context, r client.Client, captures []*captured) (map[publishKey]*publishResult, error) {
+	keys := []publishKey{}
+	snapshots := make(map[publishKey]*capturerv1alpha1.Snapshot)
+	capturers := make(map[publishKey][]*capturerv1alpha1.Capturer)
+	changed := make(map[publishKey]bool)
+	for _, cd := range captures {
+		c := cd.capturer
+		for _, outputName := range c.Spec.Outputs {
+			key := publishKey{
+				output: types.NamespacedName{
+					Namespace: c.GetNamespace(),
+					Name:      outputName,
+				},
+				hash: cd.hash,
+			}
+			if _, ok := changed[key]; !ok {
+				keys = append(keys, key)
+				snapshots[key] = cd.snapshot
+			}
+			capturers[key] = append(capturers[key], c)
+
+			if cd.snapshot.Deleted || cd.record.published[outputName] != cd.hash {
+				changed[key] = true
+			} else if _, ok := changed[key]; !ok {
+				changed[key] = false
 			}
-			return manifest, nil
 		}
+	}
 
-	default:
-		return nil, fmt.Errorf("unsupported resource type %s", resourceKind)
+	manifests := make(map[types.NamespacedName][]publishKey)
+	for _, key := range keys {
+		manifests[key.output] = append(manifests[key.output], key)
 	}
 
-	return nil, fmt.Errorf("failed to cast resource %v into resourceKind %s", resource, resourceKind)
-}
+	results := make(map[publishKey]*publishResult)
+	errs := []error{}
+	for _, key := range keys {
+		if overlapping := manifests[key.output]; len(overlapping) > 1 {
+			results[key] = &publishResult{err: overlapError(key.output, overlapping, capturers)}
+			continue
+		}
+		if !changed[key] {
+			results[key] = &publishResult{skipped: true}
+			continue
+		}
 
-func publish(ctx context.Context, r client.Client, c *capturerv1alpha1.Capturer, m []byte) error {
-	outputs := make(map[string]capturerv1alpha1.Output)
-	for _, outputName := range c.Spec.Outputs {
 		var output capturerv1alpha1.Output
-		if err := r.Get(
-			ctx,
-			types.NamespacedName{
-				Namespace: c.GetNamespace(),
-				Name:      outputName,
-			},
-			&output,
-		); err != nil {
-			if errors.IsNotFound(err) {
-				return nil
+		if err := r.Get(ctx, key.output, &output); err != nil {
+			results[key] = &publishResult{err: err}
+			if !errors.IsNotFound(err) {
+				errs = append(errs, err)
 			}
+			continue
+		}
 
-			return err
+		p := output.GetPublisher()
+		if p == nil {
+			results[key] = &publishResult{
+				err: &capturerv1alpha1.OutputError{
+					Reason: capturerv1alpha1.ReasonInvalidSpec,
+					Err:    fmt.Errorf("output %s has no destination", key.output),
+				},
+			}
+			continue
 		}
 
-		outputs[outputName] = output
+		// the Output may have been changed since the Capturers were validated
+		if err := validateCapturers(&output, capturers[key]); err != nil {
+			results[key] = &publishResult{err: err}
+			continue
+		}
+
+		var pub *capturerv1alpha1.Publication
+		snapshot, err := publishedSnapshot(&output, capturers[key], snapshots[key])
+		if err != nil {
+			results[key] = &publishResult{err: err}
+			errs = append(errs, err)
+			continue
+		}
+		creds, err := resolveCredentials(ctx, r, &output)
+		if err == nil {
+			pub, err = p.Publish(key.output.Name, snapshot, creds)
+		}
+		result := &publishResult{err: err}
+		if err == nil && pub != nil {
+			result.url = pub.URL
+		}
+		results[key] = result
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		if serr := updateOutputStatus(ctx, r, key.output, func(status *capturerv1alpha1.OutputStatus) {
+			setOutputPublishStatus(status, result.url, err)
+		}); serr != nil {
+			captureLog.Error(serr, "failed to update Output status", "output", key.output)
+		}
 	}
 
-	for outputName, output := range outputs {
-		if err := output.GetPublisher().Publish(outputName, m); err != nil {
-			return err
+	return results, utilerrors.NewAggregate(errs)
+}
+
+// publishedSnapshot returns the snapshot to be published to the Output. The Outputs which never encrypt,
+// such as Slack, are given the values of a Secret captured in plaintext hashed as its diff is.
+func publishedSnapshot(output *capturerv1alpha1.Output, caps []*capturerv1alpha1.Capturer, s *capturerv1alpha1.Snapshot) (*capturerv1alpha1.Snapshot, error) {
+	if output.Encrypts() {
+		return s, nil
+	}
+	for _, c := range caps {
+		if !revealsSecret(c, s.Object) {
+			continue
 		}
+
+		manifest, err := hashSecret(s.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		hashed := *s
+		hashed.Manifest = manifest
+		return &hashed, nil
 	}
+	return s, nil
+}
 
+// overlapError reports the Capturers publishing the different manifests of the same object to the Output
+func overlapError(output types.NamespacedName, keys []publishKey, capturers map[publishKey][]*capturerv1alpha1.Capturer) error {
+	names := []string{}
+	for _, key := range keys {
+		for _, c := range capturers[key] {
+			names = append(names, c.GetName())
+		}
+	}
+	sort.Strings(names)
+
+	return &capturerv1alpha1.OutputError{
+		Reason: capturerv1alpha1.ReasonInvalidSpec,
+		Err:    fmt.Errorf("capturers %s capture the object differently for Output %s", strings.Join(names, ", "), output.Name),
+	}
+}
+
+// validateCapturers checks the Output keeps the objects targeted by each of the Capturers apart
+func validateCapturers(output *capturerv1alpha1.Output, caps []*capturerv1alpha1.Capturer) error {
+	for _, c := range caps {
+		if err := output.ValidateCapturer(c); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// resultOf returns the result of publishing the capture to the Output
+func resultOf(cd *captured, outputName string, results map[publishKey]*publishResult) (*publishResult, bool) {
+	result, ok := results[publishKey{
+		output: types.NamespacedName{
+			Namespace: cd.capturer.GetNamespace(),
+			Name:      outputName,
+		},
+		hash: cd.hash,
+	}]
+	return result, ok
+}
+
+// allSkipped reports whether publishing the capture to all the Outputs was skipped
+func allSkipped(cd *captured, results map[publishKey]*publishResult) bool {
+	for _, outputName := range cd.capturer.Spec.Outputs {
+		result, ok := resultOf(cd, outputName, results)
+		if !ok || !result.skipped {
+			return false
+		}
+	}
+	return true
+}
+
+// allSucceeded reports whether the capture has been published to all the Outputs
+func allSucceeded(cd *captured, results map[publishKey]*publishResult) bool {
+	for _, outputName := range cd.capturer.Spec.Outputs {
+		result, ok := resultOf(cd, outputName, results)
+		if !ok || result.err != nil {
+			return false
+		}
+	}
+	return true
+}
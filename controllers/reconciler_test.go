@@ -0,0 +1,387 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/yaml"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("extractManifest", func() {
+	DescribeTable("strips the cluster-managed parts of any kind",
+		func(resource, expected string) {
+			obj := &unstructured.Unstructured{}
+			Expect(yaml.Unmarshal([]byte(resource), &obj.Object)).To(Succeed())
+
+			manifest, err := extractManifest(&capturerv1alpha1.Capturer{}, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest).To(MatchYAML(expected))
+		},
+		Entry("a Deployment", `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: coredns
+  namespace: kube-system
+  uid: 5b1c5d4e-3a7f-4c47-9a4f-2c3e0b7d1a10
+  resourceVersion: "1234"
+  generation: 3
+  creationTimestamp: "2021-03-01T12:00:00Z"
+  labels:
+    k8s-app: kube-dns
+  annotations:
+    deployment.kubernetes.io/revision: "2"
+  managedFields:
+  - manager: kubectl
+    operation: Update
+spec:
+  replicas: 2
+status:
+  replicas: 2
+  readyReplicas: 2
+`, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: coredns
+  namespace: kube-system
+  labels:
+    k8s-app: kube-dns
+spec:
+  replicas: 2
+`),
+		Entry("a cluster-scoped custom resource", `
+apiVersion: example.com/v1beta1
+kind: Widget
+metadata:
+  name: gadget
+  uid: 5b1c5d4e-3a7f-4c47-9a4f-2c3e0b7d1a11
+  resourceVersion: "42"
+  finalizers:
+  - example.com/cleanup
+spec:
+  size: large
+status:
+  phase: Ready
+`, `
+apiVersion: example.com/v1beta1
+kind: Widget
+metadata:
+  name: gadget
+spec:
+  size: large
+`),
+	)
+})
+
+var _ = Describe("ResourcePredicates", func() {
+	// meta returns the metadata of the object at the generation, which is 0 for the kinds without it
+	meta := func(generation int64) *metav1.ObjectMeta {
+		return &metav1.ObjectMeta{Namespace: "default", Name: "coredns", Generation: generation}
+	}
+
+	DescribeTable("passes the updates changing the spec",
+		func(previous, current int64, passed bool) {
+			e := event.UpdateEvent{MetaOld: meta(previous), MetaNew: meta(current)}
+			Expect(ResourcePredicates.Update(e)).To(Equal(passed))
+		},
+		Entry("a new generation", int64(1), int64(2), true),
+		Entry("the same generation", int64(2), int64(2), false),
+		Entry("a kind without the generation", int64(0), int64(0), true),
+	)
+
+	It("passes the creations and the deletions, and drops the generic events", func() {
+		Expect(ResourcePredicates.Create(event.CreateEvent{Meta: meta(1)})).To(BeTrue())
+		Expect(ResourcePredicates.Delete(event.DeleteEvent{Meta: meta(1)})).To(BeTrue())
+		Expect(ResourcePredicates.Generic(event.GenericEvent{Meta: meta(1)})).To(BeFalse())
+	})
+})
+
+var _ = Describe("matchCapturer", func() {
+	target := func(name string) *metav1.ObjectMeta {
+		return &metav1.ObjectMeta{
+			Namespace: "kube-system",
+			Name:      name,
+			Labels:    map[string]string{"app": "coredns"},
+		}
+	}
+
+	DescribeTable("matches the name and the exclusion",
+		func(spec capturerv1alpha1.CapturerSpec, name string, matched bool) {
+			c := &capturerv1alpha1.Capturer{Spec: spec}
+			Expect(validateMatch(c)).To(Succeed())
+
+			m, err := matchCapturer(context.Background(), nil, c, target(name))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m).To(Equal(matched))
+		},
+		Entry("the exact name",
+			capturerv1alpha1.CapturerSpec{ResourceName: "coredns"}, "coredns", true),
+		Entry("another exact name",
+			capturerv1alpha1.CapturerSpec{ResourceName: "coredns"}, "coredns-autoscaler", false),
+		Entry("a glob pattern",
+			capturerv1alpha1.CapturerSpec{ResourceNamePatterns: []string{"kube-*", "core*"}}, "coredns-autoscaler", true),
+		Entry("a glob pattern of a single character",
+			capturerv1alpha1.CapturerSpec{ResourceNamePatterns: []string{"node-?"}}, "node-10", false),
+		Entry("a regular expression",
+			capturerv1alpha1.CapturerSpec{ResourceNameRegex: "^core(dns)?$"}, "coredns", true),
+		Entry("an unanchored regular expression",
+			capturerv1alpha1.CapturerSpec{ResourceNameRegex: "dns"}, "coredns-autoscaler", true),
+		Entry("a name excluded by a pattern",
+			capturerv1alpha1.CapturerSpec{ResourceNamePatterns: []string{"core*"}, Exclude: []string{"*-autoscaler"}}, "coredns-autoscaler", false),
+		Entry("a name not excluded",
+			capturerv1alpha1.CapturerSpec{ResourceNamePatterns: []string{"core*"}, Exclude: []string{"*-autoscaler"}}, "coredns", true),
+		Entry("the exact name excluded",
+			capturerv1alpha1.CapturerSpec{ResourceName: "coredns", Exclude: []string{"coredns"}}, "coredns", false),
+		Entry("a selector excluding a name",
+			capturerv1alpha1.CapturerSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "coredns"}},
+				Exclude:  []string{"*-autoscaler"},
+			}, "coredns-autoscaler", false),
+		Entry("neither a name nor a selector",
+			capturerv1alpha1.CapturerSpec{}, "coredns", false),
+	)
+
+	DescribeTable("rejects the invalid patterns",
+		func(spec capturerv1alpha1.CapturerSpec, field string) {
+			err := validateMatch(&capturerv1alpha1.Capturer{Spec: spec})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(field))
+		},
+		Entry("a glob pattern", capturerv1alpha1.CapturerSpec{ResourceNamePatterns: []string{"core[dns"}}, "resourceNamePatterns"),
+		Entry("an exclusion", capturerv1alpha1.CapturerSpec{ResourceName: "coredns", Exclude: []string{"[-"}}, "exclude"),
+		Entry("a regular expression", capturerv1alpha1.CapturerSpec{ResourceNameRegex: "core(dns"}, "resourceNameRegex"),
+		Entry("a selector", capturerv1alpha1.CapturerSpec{
+			Selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Near"}}},
+		}, "selector"),
+	)
+})
+
+var _ = Describe("findCapturers", func() {
+	It("skips the invalid Capturer without failing the others", func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(capturerv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		capturer := func(name string, spec capturerv1alpha1.CapturerSpec) runtime.Object {
+			spec.NamespacedResource = true
+			spec.ResourceKind = "ConfigMap"
+			return &capturerv1alpha1.Capturer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+				Spec:       spec,
+			}
+		}
+		r := fake.NewFakeClientWithScheme(scheme,
+			capturer("invalid", capturerv1alpha1.CapturerSpec{ResourceNamePatterns: []string{"core[dns"}}),
+			capturer("valid", capturerv1alpha1.CapturerSpec{ResourceNamePatterns: []string{"core*"}}),
+		)
+
+		obj := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "coredns"}}
+		caps, err := findCapturers(context.Background(), r, schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(caps).To(HaveLen(1))
+		Expect(caps[0].GetName()).To(Equal("valid"))
+	})
+})
+
+var _ = Describe("describeChanges", func() {
+	const (
+		previous = "apiVersion: v1\nkind: Secret\ndata:\n  password: b2xk\n"
+		current  = "apiVersion: v1\nkind: Secret\ndata:\n  password: bmV3\n"
+	)
+
+	secret := func() *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("Secret")
+		obj.SetName("credentials")
+		return obj
+	}
+
+	It("compares the values of a Secret captured in plaintext hashed", func() {
+		c := &capturerv1alpha1.Capturer{}
+		c.Spec.SecretPolicy = capturerv1alpha1.SecretPolicyPlaintext
+		snapshot := &capturerv1alpha1.Snapshot{Manifest: []byte(current)}
+
+		Expect(describeChanges(c, secret(), snapshot, []byte(previous))).To(Succeed())
+		Expect(snapshot.Diff).To(ContainSubstring("-  password: sha256:" + manifestHash([]byte("old"))))
+		Expect(snapshot.Diff).To(ContainSubstring("+  password: sha256:" + manifestHash([]byte("new"))))
+		for _, s := range append(snapshot.Summary, snapshot.Diff) {
+			Expect(s).NotTo(ContainSubstring("b2xk"))
+			Expect(s).NotTo(ContainSubstring("bmV3"))
+		}
+		Expect(snapshot.Summary).NotTo(BeEmpty())
+		Expect(string(snapshot.Manifest)).To(Equal(current))
+	})
+
+	It("compares the manifests of the other kinds as they are", func() {
+		c := &capturerv1alpha1.Capturer{}
+		c.Spec.SecretPolicy = capturerv1alpha1.SecretPolicyPlaintext
+		obj := secret()
+		obj.SetKind("ConfigMap")
+		snapshot := &capturerv1alpha1.Snapshot{Manifest: []byte(current)}
+
+		Expect(describeChanges(c, obj, snapshot, []byte(previous))).To(Succeed())
+		Expect(snapshot.Diff).To(ContainSubstring("+  password: bmV3"))
+	})
+})
+
+var _ = Describe("publish", func() {
+	var (
+		ctx      context.Context
+		r        client.Client
+		slack    *httptest.Server
+		posts    map[string]int
+		payloads []string
+		mu       sync.Mutex
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		posts = map[string]int{}
+		payloads = []string{}
+		slack = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, err := ioutil.ReadAll(req.Body)
+			Expect(err).NotTo(HaveOccurred())
+
+			mu.Lock()
+			defer mu.Unlock()
+			posts[req.URL.Path]++
+			payloads = append(payloads, string(body))
+		}))
+
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(capturerv1alpha1.AddToScheme(scheme)).To(Succeed())
+		output := func(name string) runtime.Object {
+			return &capturerv1alpha1.Output{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+				Spec: capturerv1alpha1.OutputSpec{
+					Slack: &capturerv1alpha1.SlackOutput{WebhookURL: slack.URL + "/" + name},
+				},
+			}
+		}
+		r = fake.NewFakeClientWithScheme(scheme, output("team-a"), output("team-b"))
+	})
+
+	AfterEach(func() {
+		slack.Close()
+	})
+
+	// capturedBy returns the manifest captured by the Capturer publishing it to the Outputs,
+	// which have published the manifests in published
+	capturedBy := func(name, manifest string, outputs []string, published map[string]string) *captured {
+		return &captured{
+			capturer: &capturerv1alpha1.Capturer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+				Spec:       capturerv1alpha1.CapturerSpec{Outputs: outputs},
+			},
+			snapshot: &capturerv1alpha1.Snapshot{
+				Object:   corev1.ObjectReference{Kind: "ConfigMap", Namespace: "kube-system", Name: "coredns"},
+				Manifest: []byte(manifest),
+			},
+			hash:   manifestHash([]byte(manifest)),
+			record: &manifestRecord{published: published},
+		}
+	}
+
+	It("publishes the same manifest to each Output once", func() {
+		a := capturedBy("capturer-a", "data: {}\n", []string{"team-a", "team-b"}, nil)
+		b := capturedBy("capturer-b", "data: {}\n", []string{"team-b"}, nil)
+		results, err := publish(ctx, r, []*captured{a, b})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(posts).To(Equal(map[string]int{"/team-a": 1, "/team-b": 1}))
+		Expect(allSucceeded(a, results)).To(BeTrue())
+		Expect(allSucceeded(b, results)).To(BeTrue())
+	})
+
+	It("skips the manifest published through all the Capturers", func() {
+		hash := manifestHash([]byte("data: {}\n"))
+		a := capturedBy("capturer-a", "data: {}\n", []string{"team-a"}, map[string]string{"team-a": hash})
+		b := capturedBy("capturer-b", "data: {}\n", []string{"team-a"}, map[string]string{"team-a": hash})
+		results, err := publish(ctx, r, []*captured{a, b})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(posts).To(BeEmpty())
+		Expect(allSkipped(a, results)).To(BeTrue())
+
+		// the Capturer which has not published it yet publishes it for both
+		b.record.published = nil
+		results, err = publish(ctx, r, []*captured{a, b})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(posts).To(Equal(map[string]int{"/team-a": 1}))
+		Expect(allSkipped(a, results)).To(BeFalse())
+		Expect(allSucceeded(a, results)).To(BeTrue())
+	})
+
+	It("publishes none of the different manifests of the object to the same Output", func() {
+		a := capturedBy("capturer-a", "data: {}\n", []string{"team-a", "team-b"}, nil)
+		b := capturedBy("capturer-b", "data: {key: value}\n", []string{"team-b"}, nil)
+		results, err := publish(ctx, r, []*captured{a, b})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(posts).To(Equal(map[string]int{"/team-a": 1}))
+		for _, cd := range []*captured{a, b} {
+			result, ok := resultOf(cd, "team-b", results)
+			Expect(ok).To(BeTrue())
+			Expect(capturerv1alpha1.ErrorReason(result.err, "")).To(Equal(capturerv1alpha1.ReasonInvalidSpec))
+			Expect(result.err.Error()).To(ContainSubstring("capturer-a, capturer-b"))
+		}
+		result, _ := resultOf(a, "team-a", results)
+		Expect(result.err).NotTo(HaveOccurred())
+	})
+
+	It("hashes the values of a Secret captured in plaintext for the Output never encrypting them", func() {
+		const manifest = "apiVersion: v1\nkind: Secret\ndata:\n  password: c2VjcmV0\nstringData:\n  username: admin\n"
+		for _, deleted := range []bool{false, true} {
+			cd := capturedBy("secret-capturer", manifest, []string{"team-a"}, nil)
+			cd.capturer.Spec.SecretPolicy = capturerv1alpha1.SecretPolicyPlaintext
+			cd.snapshot.Object = corev1.ObjectReference{APIVersion: "v1", Kind: "Secret", Namespace: "default", Name: "credentials"}
+			cd.snapshot.Deleted = deleted
+			results, err := publish(ctx, r, []*captured{cd})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allSucceeded(cd, results)).To(BeTrue())
+			Expect(string(cd.snapshot.Manifest)).To(Equal(manifest))
+		}
+
+		Expect(payloads).To(HaveLen(2))
+		for _, payload := range payloads {
+			Expect(payload).To(ContainSubstring("password: sha256:" + manifestHash([]byte("secret"))))
+			Expect(payload).NotTo(ContainSubstring("c2VjcmV0"))
+			Expect(payload).NotTo(ContainSubstring("admin"))
+		}
+	})
+})
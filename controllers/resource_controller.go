@@ -0,0 +1,140 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ResourceController reconciles the resources of a single GroupVersionKind
+type ResourceController struct {
+	client.Client
+	Log              logr.Logger
+	Scheme           *runtime.Scheme
+	GroupVersionKind schema.GroupVersionKind
+
+	// APIReader reads the manifest stores bypassing the cache
+	APIReader client.Reader
+
+	// Audit enriches the captured changes with the requesting users if set
+	Audit *AuditReceiver
+
+	// tombstones keeps the last known state of deleted resources until they are reconciled
+	tombstones sync.Map
+}
+
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=capturers,verbs=get;list
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=capturers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=outputs,verbs=get;list
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=outputs/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=manifestsnapshots,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=*,resources=*,verbs=get;list;watch
+
+func (r *ResourceController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues(strings.ToLower(r.GroupVersionKind.Kind), req.NamespacedName)
+
+	var u unstructured.Unstructured
+	u.SetGroupVersionKind(r.GroupVersionKind)
+	deleted := false
+	if err := r.Get(ctx, req.NamespacedName, &u); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+
+		tombstone, ok := r.tombstones.Load(req.NamespacedName)
+		if !ok {
+			return ctrl.Result{}, nil
+		}
+		u = *tombstone.(*unstructured.Unstructured)
+		deleted = true
+	}
+
+	wait, retry, err := capture(ctx, r, r.APIReader, r.Audit, r.GroupVersionKind, &u, deleted)
+	if wait > 0 {
+		// the tombstone is kept until the deletion is captured after the wait
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+	if !retry {
+		r.tombstones.Delete(req.NamespacedName)
+	}
+	if err != nil {
+		log.Error(err, "failed to capture")
+
+		if retry {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+func (r *ResourceController) SetupWithManager(mgr ctrl.Manager) error {
+	c, err := controller.New(controllerName(r.GroupVersionKind), mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(r.GroupVersionKind)
+	return c.Watch(
+		&source.Kind{Type: u},
+		&tombstoneHandler{tombstones: &r.tombstones},
+		ResourcePredicates,
+	)
+}
+
+// tombstoneHandler enqueues the resource like EnqueueRequestForObject, and stores
+// the deleted resource since it cannot be fetched anymore on reconciliation.
+type tombstoneHandler struct {
+	handler.EnqueueRequestForObject
+	tombstones *sync.Map
+}
+
+func (h *tombstoneHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	if u, ok := e.Object.(*unstructured.Unstructured); ok {
+		key := types.NamespacedName{Namespace: u.GetNamespace(), Name: u.GetName()}
+		h.tombstones.Store(key, u.DeepCopy())
+	}
+	h.EnqueueRequestForObject.Delete(e, q)
+}
+
+// controllerName returns a name unique per GroupVersionKind, e.g. `deployment.v1.apps`
+func controllerName(gvk schema.GroupVersionKind) string {
+	name := strings.ToLower(gvk.Kind) + "." + gvk.Version
+	if gvk.Group != "" {
+		name += "." + gvk.Group
+	}
+	return name
+}
@@ -0,0 +1,135 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("ResourceController", func() {
+	var (
+		ctx context.Context
+		rc  *ResourceController
+		c   *capturerv1alpha1.Capturer
+		req ctrl.Request
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(capturerv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		c = &capturerv1alpha1.Capturer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "coredns-capturer", UID: "3f0c5a8e-8f0d-4d5c-9a0e-6b1f2c3d4e5f"},
+			Spec: capturerv1alpha1.CapturerSpec{
+				NamespacedResource: true,
+				ResourceKind:       "ConfigMap",
+				ResourceName:       "coredns",
+				History:            &capturerv1alpha1.HistoryPolicy{MaxCount: 5},
+			},
+		}
+		r := fake.NewFakeClientWithScheme(scheme, c, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "coredns"},
+			Data:       map[string]string{"Corefile": ".:53 {}"},
+		})
+		rc = &ResourceController{
+			Client:           r,
+			Log:              ctrl.Log.WithName("test"),
+			Scheme:           scheme,
+			GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			APIReader:        r,
+		}
+		req = ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "coredns"}}
+	})
+
+	// deleteConfigMap deletes the ConfigMap and passes its deletion event to the handler of the controller
+	deleteConfigMap := func() workqueue.RateLimitingInterface {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(rc.GroupVersionKind)
+		Expect(rc.Get(ctx, req.NamespacedName, u)).To(Succeed())
+		Expect(rc.Delete(ctx, u)).To(Succeed())
+
+		q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		h := &tombstoneHandler{tombstones: &rc.tombstones}
+		h.Delete(event.DeleteEvent{Meta: u, Object: u}, q)
+		return q
+	}
+
+	It("keeps the deleted object as a tombstone and enqueues it", func() {
+		q := deleteConfigMap()
+		defer q.ShutDown()
+
+		Expect(q.Len()).To(Equal(1))
+		item, _ := q.Get()
+		Expect(item).To(Equal(req))
+
+		tombstone, ok := rc.tombstones.Load(req.NamespacedName)
+		Expect(ok).To(BeTrue())
+		Expect(tombstone.(*unstructured.Unstructured).GetName()).To(Equal("coredns"))
+	})
+
+	It("captures the deletion from the tombstone", func() {
+		_, err := rc.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+		store, err := getManifestStore(ctx, rc, c, corev1.ObjectReference{Kind: "ConfigMap", Namespace: "default", Name: "coredns"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store).NotTo(BeNil())
+
+		q := deleteConfigMap()
+		q.ShutDown()
+		_, err = rc.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+
+		history, err := listSnapshots(ctx, rc, c)
+		Expect(err).NotTo(HaveOccurred())
+		snapshots := history["ConfigMap.default.coredns"]
+		Expect(snapshots).To(HaveLen(2))
+		Expect(snapshots[0].Spec.Deleted).To(BeTrue())
+		Expect(snapshots[1].Spec.Deleted).To(BeFalse())
+
+		// the manifest store is removed once the deletion is published, and so is the tombstone
+		store, err = getManifestStore(ctx, rc, c, corev1.ObjectReference{Kind: "ConfigMap", Namespace: "default", Name: "coredns"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store).To(BeNil())
+		_, ok := rc.tombstones.Load(req.NamespacedName)
+		Expect(ok).To(BeFalse())
+
+		// the deletion is not captured again
+		_, err = rc.Reconcile(req)
+		Expect(err).NotTo(HaveOccurred())
+		history, err = listSnapshots(ctx, rc, c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(history["ConfigMap.default.coredns"]).To(HaveLen(2))
+	})
+})
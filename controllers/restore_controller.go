@@ -0,0 +1,361 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+// restoreFieldManager is the field manager of the server-side apply restoring snapshots
+const restoreFieldManager = "manifest-capturer"
+
+// RestoreController reconciles a Restore object.
+// It fetches the snapshot from the Output and applies it with server-side apply once per generation.
+type RestoreController struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=restores,verbs=get;list;watch
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=restores/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=capturers,verbs=get;list
+// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=outputs,verbs=get;list
+
+// The kinds restored are granted by the ClusterRoles aggregated to restore-target-role,
+// see config/rbac/restore_target_role.yaml
+
+func (r *RestoreController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("restore", req.NamespacedName)
+
+	var rs capturerv1alpha1.Restore
+	if err := r.Get(ctx, req.NamespacedName, &rs); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	if rs.Status.ObservedGeneration == rs.GetGeneration() {
+		return ctrl.Result{}, nil
+	}
+
+	commit, conflicts, err := r.restore(ctx, &rs)
+	if err != nil {
+		log.Error(err, "failed to restore")
+	} else {
+		log.Info("restored", "commit", commit)
+	}
+
+	// the transient failures are retried, leaving the generation unobserved
+	transient := err != nil && capturerv1alpha1.ErrorReason(err, "") == capturerv1alpha1.ReasonFetchFailed
+	observed := rs.GetGeneration()
+	if transient {
+		observed = rs.Status.ObservedGeneration
+	}
+
+	if serr := r.updateStatus(ctx, &rs, func(status *capturerv1alpha1.RestoreStatus) {
+		setRestoreStatus(status, observed, commit, conflicts, err)
+	}); serr != nil {
+		return ctrl.Result{}, serr
+	}
+
+	if transient {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// restore applies the snapshot, returning the commit of the snapshot and the conflicting fields
+func (r *RestoreController) restore(ctx context.Context, rs *capturerv1alpha1.Restore) (string, []string, error) {
+	var c capturerv1alpha1.Capturer
+	if err := r.Get(ctx, types.NamespacedName{Namespace: rs.GetNamespace(), Name: rs.Spec.Capturer}, &c); err != nil {
+		return "", nil, err
+	}
+
+	var output capturerv1alpha1.Output
+	if err := r.Get(ctx, types.NamespacedName{Namespace: rs.GetNamespace(), Name: rs.Spec.Output}, &output); err != nil {
+		return "", nil, err
+	}
+
+	f := output.GetFetcher()
+	if f == nil {
+		return "", nil, &capturerv1alpha1.OutputError{
+			Reason: capturerv1alpha1.ReasonInvalidSpec,
+			Err:    fmt.Errorf("output %s keeps no snapshot to be restored", rs.Spec.Output),
+		}
+	}
+	creds, err := resolveCredentials(ctx, r, &output)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ref, err := restoredObject(&c, rs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	manifest, commit, err := f.Fetch(rs.Spec.Snapshot, ref, creds)
+	if err != nil {
+		return "", nil, err
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err = yaml.Unmarshal(manifest, &obj.Object); err != nil {
+		return commit, nil, err
+	}
+	if err = checkRestoredObject(obj, ref); err != nil {
+		return commit, nil, err
+	}
+	if err = checkCapturerScope(ctx, r, &c, obj); err != nil {
+		return commit, nil, err
+	}
+	if err = checkRestorable(obj); err != nil {
+		return commit, nil, err
+	}
+
+	conflicts, err := applyManifest(ctx, r, obj, rs.Spec.Force, false)
+	return commit, conflicts, err
+}
+
+// restoredObject returns the reference to the object to be restored
+func restoredObject(c *capturerv1alpha1.Capturer, rs *capturerv1alpha1.Restore) (corev1.ObjectReference, error) {
+	gvk, err := c.ResourceGroupVersionKind()
+	if err != nil {
+		return corev1.ObjectReference{}, err
+	}
+
+	ref := corev1.ObjectReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Name:       rs.Spec.ObjectName,
+	}
+	if ref.Name == "" {
+		ref.Name = c.Spec.ResourceName
+	}
+	if ref.Name == "" {
+		return ref, &capturerv1alpha1.OutputError{
+			Reason: capturerv1alpha1.ReasonInvalidSpec,
+			Err:    fmt.Errorf("objectName is required since Capturer %s has no resourceName", c.GetName()),
+		}
+	}
+
+	if c.Spec.NamespacedResource {
+		ref.Namespace = rs.Spec.ObjectNamespace
+		if ref.Namespace == "" {
+			ref.Namespace = c.Spec.ResourceNamespace
+		}
+		if ref.Namespace == "" {
+			return ref, &capturerv1alpha1.OutputError{
+				Reason: capturerv1alpha1.ReasonInvalidSpec,
+				Err:    fmt.Errorf("objectNamespace is required since Capturer %s has no resourceNamespace", c.GetName()),
+			}
+		}
+	}
+	return ref, nil
+}
+
+// checkRestoredObject rejects the manifest unless it is the object to be restored,
+// so that a snapshot tampered in the Output cannot create or overwrite any other object
+func checkRestoredObject(obj *unstructured.Unstructured, ref corev1.ObjectReference) error {
+	if obj.GetAPIVersion() == ref.APIVersion &&
+		obj.GetKind() == ref.Kind &&
+		obj.GetNamespace() == ref.Namespace &&
+		obj.GetName() == ref.Name {
+		return nil
+	}
+
+	return &capturerv1alpha1.OutputError{
+		Reason: capturerv1alpha1.ReasonInvalidSpec,
+		Err: fmt.Errorf("the snapshot is %s %s/%s while %s %s/%s is to be restored",
+			obj.GroupVersionKind(), obj.GetNamespace(), obj.GetName(),
+			ref.GroupVersionKind(), ref.Namespace, ref.Name),
+	}
+}
+
+// checkCapturerScope rejects the object unless the Capturer targets it, so that a Restore
+// overriding the object to be restored cannot apply any object out of the scope of the Capturer
+func checkCapturerScope(ctx context.Context, r client.Client, c *capturerv1alpha1.Capturer, obj *unstructured.Unstructured) error {
+	matched, err := matchCapturer(ctx, r, c, obj)
+	if err != nil {
+		return err
+	}
+	if matched {
+		return nil
+	}
+
+	return &capturerv1alpha1.OutputError{
+		Reason: capturerv1alpha1.ReasonOutOfScope,
+		Err:    fmt.Errorf("%s %s/%s is not targeted by Capturer %s", obj.GetKind(), obj.GetNamespace(), obj.GetName(), c.GetName()),
+	}
+}
+
+// checkRestorable rejects the Secrets whose values were redacted or hashed on capture,
+// which would overwrite the actual values
+func checkRestorable(obj *unstructured.Unstructured) error {
+	gvk := obj.GroupVersionKind()
+	if gvk.Group != "" || gvk.Kind != "Secret" {
+		return nil
+	}
+
+	if _, ok := obj.Object["sops"]; ok {
+		return fmt.Errorf("the values of Secret %s are encrypted", obj.GetName())
+	}
+
+	for _, field := range []string{"data", "stringData"} {
+		values, _, err := unstructured.NestedStringMap(obj.Object, field)
+		if err != nil {
+			return err
+		}
+		for k, v := range values {
+			if v == redactedValue || strings.HasPrefix(v, "sha256:") {
+				return fmt.Errorf("the value of %s.%s of Secret %s is not captured in plaintext", field, k, obj.GetName())
+			}
+		}
+	}
+	return nil
+}
+
+// validateRestorable checks the manifest can be restored by a server-side dry-run apply.
+// The conflicts with the other field managers are ignored since a restore can take their ownership.
+// The restorability is unknown if the dry-run apply is forbidden, since the kind is not granted to be restored.
+func validateRestorable(ctx context.Context, r client.Client, manifest []byte) *capturerv1alpha1.Restorability {
+	obj := &unstructured.Unstructured{}
+	err := yaml.Unmarshal(manifest, &obj.Object)
+	if err == nil {
+		err = checkRestorable(obj)
+	}
+	if err == nil {
+		force, dryRun := true, true
+		_, err = applyManifest(ctx, r, obj, force, dryRun)
+	}
+
+	if errors.IsForbidden(err) {
+		return &capturerv1alpha1.Restorability{Unknown: true, Message: err.Error()}
+	}
+	if err != nil {
+		return &capturerv1alpha1.Restorability{Message: err.Error()}
+	}
+	return &capturerv1alpha1.Restorability{Restorable: true}
+}
+
+// applyManifest applies obj with server-side apply, returning the fields conflicting with the other field managers
+func applyManifest(ctx context.Context, r client.Client, obj *unstructured.Unstructured, force, dryRun bool) ([]string, error) {
+	opts := []client.PatchOption{client.FieldOwner(restoreFieldManager)}
+	if force {
+		opts = append(opts, client.ForceOwnership)
+	}
+	if dryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+
+	if err := r.Patch(ctx, obj, client.Apply, opts...); err != nil {
+		if errors.IsConflict(err) {
+			return conflictingFields(err), err
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// conflictingFields returns the fields of the conflicts reported by the server-side apply
+func conflictingFields(err error) []string {
+	status, ok := err.(errors.APIStatus)
+	if !ok || status.Status().Details == nil {
+		return nil
+	}
+
+	fields := []string{}
+	for _, cause := range status.Status().Details.Causes {
+		fields = append(fields, strings.TrimSpace(cause.Field+" "+cause.Message))
+	}
+	return fields
+}
+
+// updateStatus applies mutate to the status of the latest Restore and updates it
+func (r *RestoreController) updateStatus(ctx context.Context, rs *capturerv1alpha1.Restore, mutate func(*capturerv1alpha1.RestoreStatus)) error {
+	key := types.NamespacedName{Namespace: rs.GetNamespace(), Name: rs.GetName()}
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var latest capturerv1alpha1.Restore
+		if err := r.Get(ctx, key, &latest); err != nil {
+			return err
+		}
+
+		mutate(&latest.Status)
+		return r.Status().Update(ctx, &latest)
+	})
+}
+
+// setRestoreStatus records the result of the restore of the generation
+func setRestoreStatus(status *capturerv1alpha1.RestoreStatus, generation int64, commit string, conflicts []string, err error) {
+	status.ObservedGeneration = generation
+	status.Commit = commit
+	status.Conflicts = conflicts
+
+	cond := capturerv1alpha1.Condition{
+		Type:   capturerv1alpha1.RestoreSucceeded,
+		Status: corev1.ConditionTrue,
+		Reason: "Restored",
+	}
+	switch {
+	case err == nil:
+		now := metav1.Now()
+		status.RestoredAt = &now
+	case len(conflicts) > 0:
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = "Conflict"
+		cond.Message = err.Error()
+	case errors.IsNotFound(err):
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = "NotFound"
+		cond.Message = err.Error()
+	default:
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = capturerv1alpha1.ErrorReason(err, "ApplyFailed")
+		cond.Message = err.Error()
+	}
+	capturerv1alpha1.SetCondition(&status.Conditions, cond)
+}
+
+func (r *RestoreController) SetupWithManager(mgr ctrl.Manager) error {
+	haveGeneration := true
+	return ctrl.NewControllerManagedBy(mgr).
+		For(
+			&capturerv1alpha1.Restore{},
+			builder.WithPredicates(Predicates(haveGeneration)),
+		).
+		Complete(r)
+}
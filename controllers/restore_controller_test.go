@@ -0,0 +1,239 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("checkRestoredObject", func() {
+	ref := corev1.ObjectReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Namespace:  "kube-system",
+		Name:       "coredns",
+	}
+
+	DescribeTable("accepts only the object to be restored",
+		func(manifest string, accepted bool) {
+			obj := &unstructured.Unstructured{}
+			Expect(yaml.Unmarshal([]byte(manifest), &obj.Object)).To(Succeed())
+
+			err := checkRestoredObject(obj, ref)
+			if accepted {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			Expect(err).To(HaveOccurred())
+			Expect(capturerv1alpha1.ErrorReason(err, "")).To(Equal(capturerv1alpha1.ReasonInvalidSpec))
+		},
+		Entry("the same object",
+			"{apiVersion: apps/v1, kind: Deployment, metadata: {namespace: kube-system, name: coredns}}", true),
+		Entry("another name",
+			"{apiVersion: apps/v1, kind: Deployment, metadata: {namespace: kube-system, name: metrics-server}}", false),
+		Entry("another namespace",
+			"{apiVersion: apps/v1, kind: Deployment, metadata: {namespace: default, name: coredns}}", false),
+		Entry("another kind",
+			"{apiVersion: rbac.authorization.k8s.io/v1, kind: ClusterRoleBinding, metadata: {name: coredns}}", false),
+		Entry("another version",
+			"{apiVersion: apps/v1beta1, kind: Deployment, metadata: {namespace: kube-system, name: coredns}}", false),
+	)
+})
+
+// pushManifests commits the manifests by their paths onto master of the repository, and pushes them to origin
+func pushManifests(r *git.Repository, manifests map[string]string) {
+	w, err := r.Worktree()
+	Expect(err).NotTo(HaveOccurred())
+
+	for path, manifest := range manifests {
+		Expect(os.MkdirAll(filepath.Join(w.Filesystem.Root(), filepath.Dir(path)), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(w.Filesystem.Root(), path), []byte(manifest), 0644)).To(Succeed())
+		_, err = w.Add(path)
+		Expect(err).NotTo(HaveOccurred())
+	}
+	_, err = w.Commit("add manifests", &git.CommitOptions{
+		Author: &object.Signature{Name: "human", Email: "human@example.com", When: time.Now()},
+	})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(r.Push(&git.PushOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"},
+	})).To(Succeed())
+}
+
+var _ = Describe("RestoreController", func() {
+	var (
+		ctx context.Context
+		dir string
+		rc  *RestoreController
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		var err error
+		dir, err = ioutil.TempDir("", "restore")
+		Expect(err).NotTo(HaveOccurred())
+
+		// the Output keeps the snapshots of the Deployments in and out of the scope of the Capturer
+		remote := filepath.Join(dir, "remote.git")
+		_, err = git.PlainInit(remote, true)
+		Expect(err).NotTo(HaveOccurred())
+		human, err := git.PlainInit(filepath.Join(dir, "human"), false)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = human.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+		Expect(err).NotTo(HaveOccurred())
+		pushManifests(human, map[string]string{
+			"kube-system/Deployment/coredns.yaml":        "{apiVersion: apps/v1, kind: Deployment, metadata: {namespace: kube-system, name: coredns}}",
+			"kube-system/Deployment/metrics-server.yaml": "{apiVersion: apps/v1, kind: Deployment, metadata: {namespace: kube-system, name: metrics-server}}",
+			"default/Deployment/coredns.yaml":            "{apiVersion: apps/v1, kind: Deployment, metadata: {namespace: default, name: coredns}}",
+		})
+
+		output := &capturerv1alpha1.Output{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "manifests"},
+			Spec: capturerv1alpha1.OutputSpec{
+				GitHub: &capturerv1alpha1.GitHubOutput{
+					Config: capturerv1alpha1.GitHubConfig{
+						RepositoryURL: remote,
+						BaseBranch:    "master",
+						ManifestPath:  "{{ .Namespace }}/{{ .Kind }}/{{ .Name }}.yaml",
+					},
+					LocalFilePath: filepath.Join(dir, "local"),
+				},
+			},
+		}
+		Expect(output.Spec.GitHub.Setup(nil)).To(Succeed())
+
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(capturerv1alpha1.AddToScheme(scheme)).To(Succeed())
+		rc = &RestoreController{
+			Client: fake.NewFakeClientWithScheme(scheme, output, &capturerv1alpha1.Capturer{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "coredns-capturer"},
+				Spec: capturerv1alpha1.CapturerSpec{
+					NamespacedResource: true,
+					ResourceAPIVersion: "apps/v1",
+					ResourceKind:       "Deployment",
+					ResourceNamespace:  "kube-system",
+					ResourceName:       "coredns",
+					Outputs:            []string{"manifests"},
+				},
+			}),
+			Log:    ctrl.Log.WithName("test"),
+			Scheme: scheme,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	DescribeTable("rejects the object out of the scope of the Capturer",
+		func(objectNamespace, objectName string) {
+			rs := &capturerv1alpha1.Restore{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "rollback", Generation: 1},
+				Spec: capturerv1alpha1.RestoreSpec{
+					Capturer:        "coredns-capturer",
+					Output:          "manifests",
+					ObjectNamespace: objectNamespace,
+					ObjectName:      objectName,
+				},
+			}
+			Expect(rc.Create(ctx, rs)).To(Succeed())
+
+			_, err := rc.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "rollback"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(rc.Get(ctx, types.NamespacedName{Namespace: "default", Name: "rollback"}, rs)).To(Succeed())
+			Expect(rs.Status.ObservedGeneration).To(Equal(int64(1)))
+			succeeded := capturerv1alpha1.FindCondition(rs.Status.Conditions, capturerv1alpha1.RestoreSucceeded)
+			Expect(succeeded).NotTo(BeNil())
+			Expect(succeeded.Status).To(Equal(corev1.ConditionFalse))
+			Expect(succeeded.Reason).To(Equal(capturerv1alpha1.ReasonOutOfScope))
+			Expect(rs.Status.RestoredAt).To(BeNil())
+
+			err = rc.Get(ctx, types.NamespacedName{Namespace: objectNamespace, Name: objectName}, &appsv1.Deployment{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		},
+		Entry("the object in another namespace", "default", "coredns"),
+		Entry("the object of another name", "kube-system", "metrics-server"),
+	)
+})
+
+// dryRunClient fails the dry-run applies with err
+type dryRunClient struct {
+	client.Client
+	err error
+}
+
+func (c *dryRunClient) Patch(_ context.Context, _ runtime.Object, _ client.Patch, _ ...client.PatchOption) error {
+	return c.err
+}
+
+var _ = Describe("validateRestorable", func() {
+	manifest := []byte("{apiVersion: apps/v1, kind: Deployment, metadata: {namespace: kube-system, name: coredns}}")
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+
+	DescribeTable("tells the restorability from the dry-run apply",
+		func(err error, restorable, unknown bool, reason string, condition corev1.ConditionStatus) {
+			s := &capturerv1alpha1.Snapshot{
+				Object: corev1.ObjectReference{Kind: "Deployment", Namespace: "kube-system", Name: "coredns"},
+			}
+			s.Restorability = validateRestorable(context.Background(), &dryRunClient{err: err}, manifest)
+			Expect(s.Restorability.Restorable).To(Equal(restorable))
+			Expect(s.Restorability.Unknown).To(Equal(unknown))
+
+			status := &capturerv1alpha1.CapturerStatus{}
+			setRestorable(status, s)
+			cond := capturerv1alpha1.FindCondition(status.Conditions, capturerv1alpha1.CapturerRestorable)
+			Expect(cond).NotTo(BeNil())
+			Expect(cond.Reason).To(Equal(reason))
+			Expect(cond.Status).To(Equal(condition))
+		},
+		Entry("the succeeded dry-run", nil, true, false, "DryRunSucceeded", corev1.ConditionTrue),
+		Entry("the rejected manifest",
+			errors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "Deployment"}, "coredns", nil),
+			false, false, "DryRunFailed", corev1.ConditionFalse),
+		Entry("the forbidden dry-run without the restore RBAC",
+			errors.NewForbidden(deployments, "coredns", nil),
+			false, true, "NoRestoreRBAC", corev1.ConditionUnknown),
+	)
+})
@@ -1,79 +0,0 @@
-/*
-
-
-Licensed under the Apache License, Version 2.0 (the "License");
-you may not use this file except in compliance with the License.
-You may obtain a copy of the License at
-
-    http://www.apache.org/licenses/LICENSE-2.0
-
-Unless required by applicable law or agreed to in writing, software
-distributed under the License is distributed on an "AS IS" BASIS,
-WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-See the License for the specific language governing permissions and
-limitations under the License.
-*/
-
-package controllers
-
-import (
-	"context"
-
-	"github.com/go-logr/logr"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/runtime"
-	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/builder"
-	"sigs.k8s.io/controller-runtime/pkg/client"
-)
-
-// SecretController reconciles a Capturer object
-type SecretController struct {
-	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
-}
-
-// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=capturers,verbs=get;list
-// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=capturers/status,verbs=get;update;patch
-// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=outputs,verbs=get;list
-// +kubebuilder:rbac:groups=capturer.stable.example.com,resources=outputs/status,verbs=get;update;patch
-// +kubebuilder:rbac:groups=,resources=secrets,verbs=get;list;watch
-// +kubebuilder:rbac:groups=,resources=secrets/status,verbs=get
-
-func (r *SecretController) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
-	log := r.Log.WithValues("secrets", req.NamespacedName)
-
-	var s corev1.Secret
-	if err := r.Get(ctx, req.NamespacedName, &s); err != nil {
-		if errors.IsNotFound(err) {
-			return ctrl.Result{}, nil
-		}
-
-		return ctrl.Result{}, err
-	}
-
-	resourceKind := "Secret"
-	retry, err := capture(ctx, r, resourceKind, &s)
-	if err != nil {
-		log.Error(err, "failed to capture")
-
-		if retry {
-			return ctrl.Result{}, err
-		}
-	}
-
-	return ctrl.Result{}, nil
-}
-
-func (r *SecretController) SetupWithManager(mgr ctrl.Manager) error {
-	haveGeneration := false
-	return ctrl.NewControllerManagedBy(mgr).
-		For(
-			&corev1.ConfigMap{},
-			builder.WithPredicates(Predicates(haveGeneration)),
-		).
-		Complete(r)
-}
@@ -0,0 +1,309 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+// updateCapturerStatus applies mutate to the latest Capturer and updates its status
+func updateCapturerStatus(ctx context.Context, r client.Client, c *capturerv1alpha1.Capturer, mutate func(*capturerv1alpha1.Capturer)) error {
+	key := types.NamespacedName{Namespace: c.GetNamespace(), Name: c.GetName()}
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var latest capturerv1alpha1.Capturer
+		if err := r.Get(ctx, key, &latest); err != nil {
+			return err
+		}
+
+		mutate(&latest)
+		return r.Status().Update(ctx, &latest)
+	})
+}
+
+// setCaptureStatus records the result of publishing the capture
+func setCaptureStatus(c *capturerv1alpha1.Capturer, cd *captured, results map[publishKey]*publishResult) {
+	now := metav1.Now()
+	status := &c.Status
+	s := cd.snapshot
+	hash := cd.hash
+
+	ref := s.Object
+	status.LastCapturedAt = &now
+	status.LastCaptured = &ref
+	if allSucceeded(cd, results) {
+		status.LastManifestHash = hash
+	}
+	setCapturing(status, s)
+	if s.Restorability != nil {
+		setRestorable(status, s)
+	}
+
+	unresolved := []string{}
+	failed := []string{}
+	outputs := []capturerv1alpha1.OutputResult{}
+	for _, name := range c.Spec.Outputs {
+		if findOutputResult(outputs, name) != nil {
+			continue
+		}
+
+		prev := findOutputResult(status.Outputs, name)
+		res, ok := resultOf(cd, name, results)
+		if !ok {
+			continue
+		}
+		if res.skipped {
+			if prev != nil {
+				outputs = append(outputs, *prev)
+			}
+			continue
+		}
+
+		result := capturerv1alpha1.OutputResult{Name: name}
+		if prev != nil {
+			result.LastPublishedAt = prev.LastPublishedAt
+		}
+
+		err := res.err
+		switch {
+		case err == nil:
+			result.Succeeded = true
+			result.LastPublishedAt = &now
+			result.URL = res.url
+		case errors.IsNotFound(err):
+			unresolved = append(unresolved, name)
+			result.Message = err.Error()
+		default:
+			failed = append(failed, name)
+			result.Message = err.Error()
+		}
+		outputs = append(outputs, result)
+	}
+	status.Outputs = outputs
+
+	resolved := outputsResolvedCondition(unresolved)
+	capturerv1alpha1.SetCondition(&status.Conditions, resolved)
+
+	published := capturerv1alpha1.Condition{
+		Type:   capturerv1alpha1.CapturerLastPublishSucceeded,
+		Status: corev1.ConditionTrue,
+		Reason: "Published",
+	}
+	if len(failed) > 0 {
+		published.Status = corev1.ConditionFalse
+		published.Reason = "PublishFailed"
+		published.Message = fmt.Sprintf("failed to publish to Outputs: %s", strings.Join(failed, ", "))
+	}
+	capturerv1alpha1.SetCondition(&status.Conditions, published)
+
+	ready := capturerv1alpha1.Condition{
+		Type:   capturerv1alpha1.CapturerReady,
+		Status: corev1.ConditionTrue,
+		Reason: "Captured",
+	}
+	if resolved.Status != corev1.ConditionTrue {
+		ready.Status = corev1.ConditionFalse
+		ready.Reason = resolved.Reason
+		ready.Message = resolved.Message
+	} else if published.Status != corev1.ConditionTrue {
+		ready.Status = corev1.ConditionFalse
+		ready.Reason = published.Reason
+		ready.Message = published.Message
+	}
+	capturerv1alpha1.SetCondition(&status.Conditions, ready)
+}
+
+// outputsResolvedCondition returns the OutputsResolved condition with the names of the missing Outputs
+func outputsResolvedCondition(unresolved []string) capturerv1alpha1.Condition {
+	resolved := capturerv1alpha1.Condition{
+		Type:   capturerv1alpha1.CapturerOutputsResolved,
+		Status: corev1.ConditionTrue,
+		Reason: "OutputsFound",
+	}
+	if len(unresolved) > 0 {
+		resolved.Status = corev1.ConditionFalse
+		resolved.Reason = "OutputNotFound"
+		resolved.Message = fmt.Sprintf("Outputs not found: %s", strings.Join(unresolved, ", "))
+	}
+	return resolved
+}
+
+// setObservedStatus records the validation of the Capturer spec of the generation.
+// Ready turns false if any Output is missing, and is left to the captures otherwise.
+func setObservedStatus(c *capturerv1alpha1.Capturer, generation int64, unresolved []string) {
+	status := &c.Status
+	status.ObservedGeneration = generation
+
+	resolved := outputsResolvedCondition(unresolved)
+	capturerv1alpha1.SetCondition(&status.Conditions, resolved)
+	if resolved.Status != corev1.ConditionTrue {
+		capturerv1alpha1.SetCondition(&status.Conditions, capturerv1alpha1.Condition{
+			Type:    capturerv1alpha1.CapturerReady,
+			Status:  corev1.ConditionFalse,
+			Reason:  resolved.Reason,
+			Message: resolved.Message,
+		})
+	}
+}
+
+// setInvalidSpecStatus records the Capturer spec of the generation is invalid
+func setInvalidSpecStatus(c *capturerv1alpha1.Capturer, generation int64, err error) {
+	c.Status.ObservedGeneration = generation
+	capturerv1alpha1.SetCondition(&c.Status.Conditions, capturerv1alpha1.Condition{
+		Type:    capturerv1alpha1.CapturerReady,
+		Status:  corev1.ConditionFalse,
+		Reason:  capturerv1alpha1.ReasonInvalidSpec,
+		Message: err.Error(),
+	})
+}
+
+// setRestorable records the result of the dry-run apply of the captured manifest
+func setRestorable(status *capturerv1alpha1.CapturerStatus, s *capturerv1alpha1.Snapshot) {
+	cond := capturerv1alpha1.Condition{
+		Type:   capturerv1alpha1.CapturerRestorable,
+		Status: corev1.ConditionTrue,
+		Reason: "DryRunSucceeded",
+	}
+	switch {
+	case s.Restorability.Unknown:
+		cond.Status = corev1.ConditionUnknown
+		cond.Reason = "NoRestoreRBAC"
+		cond.Message = fmt.Sprintf("%s: %s", s, s.Restorability.Message)
+	case !s.Restorability.Restorable:
+		cond.Status = corev1.ConditionFalse
+		cond.Reason = "DryRunFailed"
+		cond.Message = fmt.Sprintf("%s: %s", s, s.Restorability.Message)
+	}
+	capturerv1alpha1.SetCondition(&status.Conditions, cond)
+}
+
+// setExtractFailedStatus records the failure to extract the manifest, which is
+// typically caused by an invalid ignore rule or patch
+func setExtractFailedStatus(status *capturerv1alpha1.CapturerStatus, err error) {
+	capturerv1alpha1.SetCondition(&status.Conditions, capturerv1alpha1.Condition{
+		Type:    capturerv1alpha1.CapturerReady,
+		Status:  corev1.ConditionFalse,
+		Reason:  "ExtractFailed",
+		Message: err.Error(),
+	})
+}
+
+// setCapturing lists the captured object first in the capturing objects until it is deleted,
+// keeping the most recently captured ones up to MaxCapturing
+func setCapturing(status *capturerv1alpha1.CapturerStatus, s *capturerv1alpha1.Snapshot) {
+	capturing := []corev1.ObjectReference{}
+	if !s.Deleted {
+		capturing = append(capturing, s.Object)
+	}
+	for _, ref := range status.Capturing {
+		if ref.Kind == s.Object.Kind &&
+			ref.Namespace == s.Object.Namespace &&
+			ref.Name == s.Object.Name {
+			continue
+		}
+		if len(capturing) == capturerv1alpha1.MaxCapturing {
+			break
+		}
+		capturing = append(capturing, ref)
+	}
+	status.Capturing = capturing
+}
+
+func findOutputResult(results []capturerv1alpha1.OutputResult, name string) *capturerv1alpha1.OutputResult {
+	for i := range results {
+		if results[i].Name == name {
+			return &results[i]
+		}
+	}
+	return nil
+}
+
+// manifestHash returns the hex encoded SHA-256 hash of the manifest
+func manifestHash(manifest []byte) string {
+	sum := sha256.Sum256(manifest)
+	return hex.EncodeToString(sum[:])
+}
+
+// updateOutputStatus applies mutate to the status of the Output
+func updateOutputStatus(ctx context.Context, r client.Client, key types.NamespacedName, mutate func(*capturerv1alpha1.OutputStatus)) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var latest capturerv1alpha1.Output
+		if err := r.Get(ctx, key, &latest); err != nil {
+			return err
+		}
+
+		mutate(&latest.Status)
+		return r.Status().Update(ctx, &latest)
+	})
+}
+
+// setOutputSetupStatus records the result of the setup, which verifies the destination is available
+func setOutputSetupStatus(status *capturerv1alpha1.OutputStatus, err error) {
+	ready := capturerv1alpha1.Condition{
+		Type:   capturerv1alpha1.OutputReady,
+		Status: corev1.ConditionTrue,
+		Reason: "SetupSucceeded",
+	}
+	if err != nil {
+		now := metav1.Now()
+		status.LastError = err.Error()
+		status.LastErrorAt = &now
+
+		ready.Status = corev1.ConditionFalse
+		ready.Reason = capturerv1alpha1.ErrorReason(err, capturerv1alpha1.ReasonSetupFailed)
+		ready.Message = err.Error()
+	}
+	capturerv1alpha1.SetCondition(&status.Conditions, ready)
+}
+
+// setOutputPublishStatus records the result of a publication
+func setOutputPublishStatus(status *capturerv1alpha1.OutputStatus, url string, err error) {
+	now := metav1.Now()
+	ready := capturerv1alpha1.Condition{
+		Type:   capturerv1alpha1.OutputReady,
+		Status: corev1.ConditionTrue,
+		Reason: "Published",
+	}
+	if err != nil {
+		status.FailureCount++
+		status.LastError = err.Error()
+		status.LastErrorAt = &now
+
+		ready.Status = corev1.ConditionFalse
+		ready.Reason = capturerv1alpha1.ErrorReason(err, capturerv1alpha1.ReasonPublishFailed)
+		ready.Message = err.Error()
+	} else {
+		status.PublishCount++
+		status.LastPublishedAt = &now
+		if url != "" {
+			status.LastPublicationURL = url
+		}
+	}
+	capturerv1alpha1.SetCondition(&status.Conditions, ready)
+}
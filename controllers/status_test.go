@@ -0,0 +1,98 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("setCapturing", func() {
+	snapshot := func(name string, deleted bool) *capturerv1alpha1.Snapshot {
+		return &capturerv1alpha1.Snapshot{
+			Object:  corev1.ObjectReference{Kind: "ConfigMap", Namespace: "default", Name: name},
+			Deleted: deleted,
+		}
+	}
+
+	It("lists the most recently captured objects up to the maximum", func() {
+		status := &capturerv1alpha1.CapturerStatus{}
+		for i := 0; i < capturerv1alpha1.MaxCapturing+5; i++ {
+			setCapturing(status, snapshot(fmt.Sprintf("cm-%d", i), false))
+		}
+		Expect(status.Capturing).To(HaveLen(capturerv1alpha1.MaxCapturing))
+		Expect(status.Capturing[0].Name).To(Equal(fmt.Sprintf("cm-%d", capturerv1alpha1.MaxCapturing+4)))
+		Expect(status.Capturing[capturerv1alpha1.MaxCapturing-1].Name).To(Equal("cm-5"))
+
+		setCapturing(status, snapshot("cm-10", false))
+		Expect(status.Capturing).To(HaveLen(capturerv1alpha1.MaxCapturing))
+		Expect(status.Capturing[0].Name).To(Equal("cm-10"))
+
+		setCapturing(status, snapshot("cm-10", true))
+		Expect(status.Capturing).To(HaveLen(capturerv1alpha1.MaxCapturing - 1))
+		for _, ref := range status.Capturing {
+			Expect(ref.Name).NotTo(Equal("cm-10"))
+		}
+	})
+})
+
+var _ = Describe("setCaptureStatus", func() {
+	// capture returns the capture by the Capturer publishing to two Outputs, whose results are given
+	capture := func(c *capturerv1alpha1.Capturer, manifest string, results ...*publishResult) (*captured, map[publishKey]*publishResult) {
+		cd := &captured{
+			capturer: c,
+			snapshot: &capturerv1alpha1.Snapshot{
+				Object:   corev1.ObjectReference{Kind: "ConfigMap", Namespace: "default", Name: "coredns"},
+				Manifest: []byte(manifest),
+			},
+			hash: manifestHash([]byte(manifest)),
+		}
+		published := map[publishKey]*publishResult{}
+		for i, name := range c.Spec.Outputs {
+			published[publishKey{output: types.NamespacedName{Namespace: "default", Name: name}, hash: cd.hash}] = results[i]
+		}
+		return cd, published
+	}
+
+	It("keeps the hash of the manifest last published to all the Outputs", func() {
+		c := &capturerv1alpha1.Capturer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "coredns-capturer"},
+			Spec:       capturerv1alpha1.CapturerSpec{Outputs: []string{"github", "slack"}},
+		}
+		cd, results := capture(c, "data: {}\n", &publishResult{}, &publishResult{skipped: true})
+		setCaptureStatus(c, cd, results)
+		Expect(c.Status.LastManifestHash).To(Equal(cd.hash))
+		published := cd.hash
+
+		cd, results = capture(c, "data: {key: value}\n", &publishResult{}, &publishResult{err: errors.New("webhook responded 500")})
+		setCaptureStatus(c, cd, results)
+		Expect(c.Status.LastManifestHash).To(Equal(published))
+		Expect(c.Status.LastCaptured.Name).To(Equal("coredns"))
+		cond := capturerv1alpha1.FindCondition(c.Status.Conditions, capturerv1alpha1.CapturerLastPublishSucceeded)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+		Expect(cond.Message).To(ContainSubstring("slack"))
+	})
+})
@@ -0,0 +1,231 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+const (
+	// manifestStoreType is the type of the Secrets storing the manifests last captured
+	manifestStoreType corev1.SecretType = "capturer.stable.example.com/manifest"
+
+	// manifestStoreLabel labels the manifest stores and the ManifestSnapshots with the UID of the Capturer
+	manifestStoreLabel = "capturer.stable.example.com/capturer-uid"
+
+	// manifestStoreObjectAnnotation annotates the manifest store with the captured object
+	manifestStoreObjectAnnotation = "capturer.stable.example.com/object"
+
+	// manifestKey is the key of the manifest in the manifest store
+	manifestKey = "manifest"
+
+	// publishedKeyPrefix prefixes the name of the Output to the key of the hash of the manifest last published to it
+	publishedKeyPrefix = "published."
+)
+
+// manifestRecord is the state of a captured object kept in its manifest store
+type manifestRecord struct {
+	// manifest is the manifest last published to all the Outputs, or nil if none
+	manifest []byte
+
+	// published is the hash of the manifest last published per Output,
+	// which is used to skip publishing unchanged manifests
+	published map[string]string
+}
+
+// manifestStoreName returns the name of the Secret storing the manifest of the object last captured
+// by the Capturer, e.g. `configmap-capturer-3f2a1c9b0d`. A Secret is used per object since
+// a Secret is limited to 1MiB, and the manifest may hold the values of a captured Secret.
+func manifestStoreName(c *capturerv1alpha1.Capturer, ref corev1.ObjectReference) string {
+	sum := sha256.Sum256([]byte(manifestStoreKey(ref)))
+	name := c.GetName()
+	if len(name) > 242 {
+		name = name[:242]
+	}
+	return name + "-" + hex.EncodeToString(sum[:])[:10]
+}
+
+// manifestStoreKey returns the key identifying the captured object, e.g. `ConfigMap.kube-system.coredns`
+func manifestStoreKey(ref corev1.ObjectReference) string {
+	parts := []string{ref.Kind}
+	if ref.Namespace != "" {
+		parts = append(parts, ref.Namespace)
+	}
+	parts = append(parts, ref.Name)
+	return strings.Join(parts, ".")
+}
+
+// controlledByCapturer reports whether obj is controlled by a Capturer, like the manifest stores
+// and the ManifestSnapshots, which are never captured not to capture them endlessly
+func controlledByCapturer(obj metav1.Object) bool {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil {
+		return false
+	}
+	gv, err := schema.ParseGroupVersion(owner.APIVersion)
+	return err == nil && gv.Group == capturerv1alpha1.GroupVersion.Group && owner.Kind == "Capturer"
+}
+
+// getManifestStore returns the manifest store of the object, or nil if it has never been captured.
+// It fails if the Secret of the name is not controlled by the Capturer.
+func getManifestStore(ctx context.Context, r client.Reader, c *capturerv1alpha1.Capturer, ref corev1.ObjectReference) (*corev1.Secret, error) {
+	var secret corev1.Secret
+	key := types.NamespacedName{Namespace: c.GetNamespace(), Name: manifestStoreName(c, ref)}
+	if err := r.Get(ctx, key, &secret); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if !metav1.IsControlledBy(&secret, c) || secret.Type != manifestStoreType {
+		return nil, fmt.Errorf("%s is not the manifest store of Capturer %s", key, c.GetName())
+	}
+	return &secret, nil
+}
+
+// loadRecord returns the record of the object captured by the Capturer, which is empty
+// if it has never been captured. The record should be read bypassing the cache,
+// since a stale record would publish the same manifest twice.
+func loadRecord(ctx context.Context, r client.Reader, c *capturerv1alpha1.Capturer, ref corev1.ObjectReference) (*manifestRecord, error) {
+	record := &manifestRecord{published: map[string]string{}}
+	secret, err := getManifestStore(ctx, r, c, ref)
+	if err != nil || secret == nil {
+		return record, err
+	}
+
+	for k, v := range secret.Data {
+		switch {
+		case k == manifestKey:
+			record.manifest = v
+		case strings.HasPrefix(k, publishedKeyPrefix):
+			record.published[strings.TrimPrefix(k, publishedKeyPrefix)] = string(v)
+		}
+	}
+	return record, nil
+}
+
+// storeRecord records the hash of the manifest per Output it is published to, and the manifest
+// once it is published to all the Outputs so that the diff is taken against the last one all of them have.
+// The manifest store of a deleted object is deleted once the deletion is published to all the Outputs.
+func storeRecord(ctx context.Context, r client.Client, reader client.Reader, cd *captured, results map[publishKey]*publishResult) error {
+	c := cd.capturer
+	snapshot := cd.snapshot
+	published := []string{}
+	for _, name := range c.Spec.Outputs {
+		if result, ok := resultOf(cd, name, results); ok && result.err == nil && !result.skipped {
+			published = append(published, name)
+		}
+	}
+	completed := allSucceeded(cd, results)
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		secret, err := getManifestStore(ctx, reader, c, snapshot.Object)
+		if err != nil {
+			return err
+		}
+
+		if snapshot.Deleted {
+			if secret == nil || !completed {
+				return nil
+			}
+			if err = r.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			return nil
+		}
+
+		create := secret == nil
+		if create {
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: c.GetNamespace(),
+					Name:      manifestStoreName(c, snapshot.Object),
+					Labels: map[string]string{
+						manifestStoreLabel: string(c.GetUID()),
+					},
+					Annotations: map[string]string{
+						manifestStoreObjectAnnotation: manifestStoreKey(snapshot.Object),
+					},
+					OwnerReferences: []metav1.OwnerReference{
+						*metav1.NewControllerRef(c, capturerv1alpha1.GroupVersion.WithKind("Capturer")),
+					},
+				},
+				Type: manifestStoreType,
+			}
+		}
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+
+		changed := false
+		set := func(key string, value []byte) {
+			if prev, ok := secret.Data[key]; !ok || string(prev) != string(value) {
+				secret.Data[key] = value
+				changed = true
+			}
+		}
+		for _, name := range published {
+			set(publishedKeyPrefix+name, []byte(cd.hash))
+		}
+		for key := range secret.Data {
+			if strings.HasPrefix(key, publishedKeyPrefix) && !contains(c.Spec.Outputs, strings.TrimPrefix(key, publishedKeyPrefix)) {
+				delete(secret.Data, key)
+				changed = true
+			}
+		}
+		if completed {
+			set(manifestKey, snapshot.Manifest)
+		}
+
+		switch {
+		case !changed:
+			return nil
+		case create:
+			return r.Create(ctx, secret)
+		default:
+			return r.Update(ctx, secret)
+		}
+	})
+}
+
+// countManifestStores returns the number of the objects captured by the Capturer and not deleted,
+// each of which has its manifest store
+func countManifestStores(ctx context.Context, r client.Reader, c *capturerv1alpha1.Capturer) (int32, error) {
+	var list corev1.SecretList
+	if err := r.List(ctx, &list,
+		client.InNamespace(c.GetNamespace()),
+		client.MatchingLabels{manifestStoreLabel: string(c.GetUID())},
+	); err != nil {
+		return 0, err
+	}
+	return int32(len(list.Items)), nil
+}
@@ -0,0 +1,206 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	capturerv1alpha1 "github.com/terakoya76/manifest-capturer/apis/capturer/v1alpha1"
+)
+
+var _ = Describe("manifest store", func() {
+	var (
+		ctx      context.Context
+		r        client.Client
+		c        *capturerv1alpha1.Capturer
+		snapshot *capturerv1alpha1.Snapshot
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(capturerv1alpha1.AddToScheme(scheme)).To(Succeed())
+		r = fake.NewFakeClientWithScheme(scheme)
+
+		c = &capturerv1alpha1.Capturer{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret-capturer", UID: "6f1d3c52-7c1e-4f0b-9e1a-2b0c6e0d3a44"},
+			Spec: capturerv1alpha1.CapturerSpec{
+				NamespacedResource: true,
+				ResourceKind:       "Secret",
+				Selector:           &metav1.LabelSelector{},
+				Outputs:            []string{"github-output", "slack-output"},
+			},
+		}
+		snapshot = &capturerv1alpha1.Snapshot{
+			Object:   corev1.ObjectReference{Kind: "Secret", Namespace: "default", Name: "db"},
+			Manifest: []byte("data:\n  password: c2VjcmV0\n"),
+		}
+	})
+
+	// store records the snapshot published to the Outputs of the Capturer, failing with errs per Output
+	store := func(snapshot *capturerv1alpha1.Snapshot, errs map[string]error) error {
+		cd := &captured{capturer: c, snapshot: snapshot, hash: manifestHash(snapshot.Manifest)}
+		results := map[publishKey]*publishResult{}
+		for _, name := range c.Spec.Outputs {
+			key := publishKey{output: types.NamespacedName{Namespace: c.GetNamespace(), Name: name}, hash: cd.hash}
+			results[key] = &publishResult{err: errs[name]}
+		}
+		return storeRecord(ctx, r, r, cd, results)
+	}
+
+	It("keeps the manifest in a Secret per object controlled by the Capturer", func() {
+		Expect(store(snapshot, nil)).To(Succeed())
+
+		other := *snapshot
+		other.Object.Name = "cache"
+		other.Manifest = []byte("data: {}\n")
+		Expect(store(&other, nil)).To(Succeed())
+
+		record, err := loadRecord(ctx, r, c, snapshot.Object)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(record.manifest)).To(Equal("data:\n  password: c2VjcmV0\n"))
+		Expect(record.published).To(Equal(map[string]string{
+			"github-output": manifestHash(snapshot.Manifest),
+			"slack-output":  manifestHash(snapshot.Manifest),
+		}))
+		record, err = loadRecord(ctx, r, c, other.Object)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(record.manifest)).To(Equal("data: {}\n"))
+
+		var secret corev1.Secret
+		key := types.NamespacedName{Namespace: "default", Name: manifestStoreName(c, snapshot.Object)}
+		Expect(r.Get(ctx, key, &secret)).To(Succeed())
+		Expect(secret.Type).To(Equal(manifestStoreType))
+		Expect(metav1.IsControlledBy(&secret, c)).To(BeTrue())
+
+		snapshot.Deleted = true
+		Expect(store(snapshot, nil)).To(Succeed())
+		Expect(errors.IsNotFound(r.Get(ctx, key, &secret))).To(BeTrue())
+		record, err = loadRecord(ctx, r, c, snapshot.Object)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.manifest).To(BeNil())
+		Expect(record.published).To(BeEmpty())
+	})
+
+	It("keeps the previous manifest until the manifest is published to all the Outputs", func() {
+		Expect(store(snapshot, nil)).To(Succeed())
+
+		previous := snapshot.Manifest
+		snapshot.Manifest = []byte("data:\n  password: bmV3\n")
+		Expect(store(snapshot, map[string]error{"slack-output": fmt.Errorf("webhook unreachable")})).To(Succeed())
+
+		record, err := loadRecord(ctx, r, c, snapshot.Object)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.manifest).To(Equal(previous))
+		Expect(record.published["github-output"]).To(Equal(manifestHash(snapshot.Manifest)))
+		Expect(record.published["slack-output"]).To(Equal(manifestHash(previous)))
+
+		// the deletion is not recorded until it is published to all the Outputs
+		snapshot.Deleted = true
+		Expect(store(snapshot, map[string]error{"slack-output": fmt.Errorf("webhook unreachable")})).To(Succeed())
+		record, err = loadRecord(ctx, r, c, snapshot.Object)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.manifest).To(Equal(previous))
+	})
+
+	It("skips publishing the manifest already published to the Output", func() {
+		Expect(store(snapshot, nil)).To(Succeed())
+		record, err := loadRecord(ctx, r, c, snapshot.Object)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the Outputs do not exist, so the publication fails unless it is skipped
+		cd := &captured{capturer: c, snapshot: snapshot, hash: manifestHash(snapshot.Manifest), record: record}
+		results, err := publish(ctx, r, []*captured{cd})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(allSkipped(cd, results)).To(BeTrue())
+
+		delete(record.published, "slack-output")
+		results, _ = publish(ctx, r, []*captured{cd})
+		Expect(allSkipped(cd, results)).To(BeFalse())
+		result, _ := resultOf(cd, "slack-output", results)
+		Expect(errors.IsNotFound(result.err)).To(BeTrue())
+	})
+
+	It("forgets the Outputs removed from the Capturer", func() {
+		Expect(store(snapshot, nil)).To(Succeed())
+
+		c.Spec.Outputs = []string{"github-output"}
+		snapshot.Manifest = []byte("data:\n  password: bmV3\n")
+		Expect(store(snapshot, nil)).To(Succeed())
+
+		record, err := loadRecord(ctx, r, c, snapshot.Object)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.published).To(Equal(map[string]string{"github-output": manifestHash(snapshot.Manifest)}))
+	})
+
+	It("counts the objects captured and not deleted", func() {
+		for _, name := range []string{"db", "cache", "queue"} {
+			other := *snapshot
+			other.Object.Name = name
+			Expect(store(&other, nil)).To(Succeed())
+		}
+		snapshot.Deleted = true
+		Expect(store(snapshot, nil)).To(Succeed())
+
+		count, err := countManifestStores(ctx, r, c)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count).To(Equal(int32(2)))
+	})
+
+	It("never overwrites the Secret not controlled by the Capturer", func() {
+		foreign := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: manifestStoreName(c, snapshot.Object)},
+			Data:       map[string][]byte{manifestKey: []byte("precious")},
+		}
+		Expect(r.Create(ctx, foreign)).To(Succeed())
+
+		Expect(store(snapshot, nil)).NotTo(Succeed())
+		_, err := loadRecord(ctx, r, c, snapshot.Object)
+		Expect(err).To(HaveOccurred())
+
+		var secret corev1.Secret
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: foreign.GetName()}, &secret)).To(Succeed())
+		Expect(string(secret.Data[manifestKey])).To(Equal("precious"))
+	})
+
+	It("never captures the manifest stores", func() {
+		Expect(store(snapshot, nil)).To(Succeed())
+
+		var secret corev1.Secret
+		Expect(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: manifestStoreName(c, snapshot.Object)}, &secret)).To(Succeed())
+		matched, err := matchCapturer(ctx, r, c, &secret)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matched).To(BeFalse())
+
+		matched, err = matchCapturer(ctx, r, c, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matched).To(BeTrue())
+	})
+})
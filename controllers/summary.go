@@ -0,0 +1,355 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// fieldChange is a change of a field between the previous and the current object.
+// previous is nil if the field is added, and current is nil if it is removed.
+type fieldChange struct {
+	path     string
+	previous interface{}
+	current  interface{}
+}
+
+var (
+	containerPath      = regexp.MustCompile(`\.(?:initContainers|containers)\[([^\]]+)\]$`)
+	containerImagePath = regexp.MustCompile(`\.(?:initContainers|containers)\[([^\]]+)\]\.image$`)
+	simpleFieldKey     = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// summarizeChanges describes the field-level changes between the previous and
+// the current manifest, e.g. `spec.replicas 2→3`. It describes nothing without
+// the previous manifest, whose fields would all be reported as added.
+func summarizeChanges(previous, current []byte) ([]string, error) {
+	var prevObj, curObj map[string]interface{}
+	if err := yaml.Unmarshal(previous, &prevObj); err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(current, &curObj); err != nil {
+		return nil, err
+	}
+
+	summary := []string{}
+	if len(prevObj) == 0 {
+		return summary, nil
+	}
+
+	kind, _ := curObj["kind"].(string)
+
+	if kind == "Role" || kind == "ClusterRole" {
+		summary = append(summary, summarizeRules(kind, prevObj["rules"], curObj["rules"])...)
+		delete(prevObj, "rules")
+		delete(curObj, "rules")
+	}
+
+	changes := []fieldChange{}
+	diffFields("", prevObj, curObj, &changes)
+	for _, c := range changes {
+		summary = append(summary, describeChange(kind, c))
+	}
+
+	return summary, nil
+}
+
+// diffFields collects the changes between previous and current under the path.
+// The elements of lists are compared by their names if all of them are named.
+func diffFields(path string, previous, current interface{}, changes *[]fieldChange) {
+	if reflect.DeepEqual(previous, current) {
+		return
+	}
+
+	prevMap, prevIsMap := previous.(map[string]interface{})
+	curMap, curIsMap := current.(map[string]interface{})
+	if prevIsMap && curIsMap {
+		keys := []string{}
+		for k := range prevMap {
+			keys = append(keys, k)
+		}
+		for k := range curMap {
+			if _, ok := prevMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			diffFields(fieldPath(path, k), prevMap[k], curMap[k], changes)
+		}
+		return
+	}
+
+	prevList, prevIsList := previous.([]interface{})
+	curList, curIsList := current.([]interface{})
+	if prevIsList && curIsList {
+		prevNamed, prevOk := namedElements(prevList)
+		curNamed, curOk := namedElements(curList)
+		if prevOk && curOk {
+			names := []string{}
+			for _, e := range prevList {
+				names = append(names, elementName(e))
+			}
+			for _, e := range curList {
+				if _, ok := prevNamed[elementName(e)]; !ok {
+					names = append(names, elementName(e))
+				}
+			}
+
+			for _, name := range names {
+				diffFields(fmt.Sprintf("%s[%s]", path, name), prevNamed[name], curNamed[name], changes)
+			}
+			return
+		}
+	}
+
+	*changes = append(*changes, fieldChange{path: path, previous: previous, current: current})
+}
+
+// fieldPath appends the key to the path, quoting it by brackets unless it is simple
+func fieldPath(path, key string) string {
+	if !simpleFieldKey.MatchString(key) {
+		return fmt.Sprintf("%s[%s]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// namedElements indexes the elements of the list by their names.
+// It reports false if any of them has no name.
+func namedElements(list []interface{}) (map[string]interface{}, bool) {
+	named := make(map[string]interface{})
+	for _, e := range list {
+		name := elementName(e)
+		if name == "" {
+			return nil, false
+		}
+		if _, ok := named[name]; ok {
+			return nil, false
+		}
+		named[name] = e
+	}
+	return named, true
+}
+
+func elementName(e interface{}) string {
+	m, ok := e.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, _ := m["name"].(string)
+	return name
+}
+
+// describeChange returns the human readable description of the change
+func describeChange(kind string, c fieldChange) string {
+	if m := containerImagePath.FindStringSubmatch(c.path); m != nil && c.previous != nil && c.current != nil {
+		prevImage, curImage := shortenImages(formatValue(c.previous), formatValue(c.current))
+		return fmt.Sprintf("container %s image %s→%s", m[1], prevImage, curImage)
+	}
+
+	if m := containerPath.FindStringSubmatch(c.path); m != nil {
+		switch {
+		case c.previous == nil:
+			return fmt.Sprintf("container %s added", m[1])
+		case c.current == nil:
+			return fmt.Sprintf("container %s removed", m[1])
+		}
+	}
+
+	if key, ok := dataKey(kind, c.path); ok {
+		switch {
+		case c.previous == nil:
+			return fmt.Sprintf("%s key %s added", kind, key)
+		case c.current == nil:
+			return fmt.Sprintf("%s key %s removed", kind, key)
+		default:
+			added, removed := lineStat(formatValue(c.previous), formatValue(c.current))
+			return fmt.Sprintf("%s key %s modified (+%d/-%d lines)", kind, key, added, removed)
+		}
+	}
+
+	switch {
+	case c.previous == nil:
+		return fmt.Sprintf("%s added", c.path)
+	case c.current == nil:
+		return fmt.Sprintf("%s removed", c.path)
+	}
+
+	if !isScalar(c.previous) || !isScalar(c.current) {
+		return fmt.Sprintf("%s changed", c.path)
+	}
+
+	prev, cur := formatValue(c.previous), formatValue(c.current)
+	if strings.Contains(prev, "\n") || strings.Contains(cur, "\n") {
+		added, removed := lineStat(prev, cur)
+		return fmt.Sprintf("%s modified (+%d/-%d lines)", c.path, added, removed)
+	}
+	return fmt.Sprintf("%s %s→%s", c.path, prev, cur)
+}
+
+// dataKey returns the key of data or binaryData if the path points it
+func dataKey(kind, path string) (string, bool) {
+	if kind != "ConfigMap" && kind != "Secret" {
+		return "", false
+	}
+
+	for _, field := range []string{"data", "binaryData", "stringData"} {
+		if strings.HasPrefix(path, field+".") {
+			return strings.TrimPrefix(path, field+"."), true
+		}
+		if strings.HasPrefix(path, field+"[") && strings.HasSuffix(path, "]") {
+			return path[len(field)+1 : len(path)-1], true
+		}
+	}
+	return "", false
+}
+
+// summarizeRules describes the added and removed rules of Role and ClusterRole,
+// e.g. `ClusterRole rule added: secrets get,list`
+func summarizeRules(kind string, previous, current interface{}) []string {
+	prevRules := ruleSet(previous)
+	curRules := ruleSet(current)
+
+	summary := []string{}
+	for _, r := range curRules {
+		if !contains(prevRules, r) {
+			summary = append(summary, fmt.Sprintf("%s rule added: %s", kind, r))
+		}
+	}
+	for _, r := range prevRules {
+		if !contains(curRules, r) {
+			summary = append(summary, fmt.Sprintf("%s rule removed: %s", kind, r))
+		}
+	}
+	return summary
+}
+
+// ruleSet formats the PolicyRules, e.g. `deployments.apps get,list`
+func ruleSet(rules interface{}) []string {
+	list, _ := rules.([]interface{})
+	formatted := []string{}
+	for _, e := range list {
+		rule, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		groups := stringList(rule["apiGroups"])
+		targets := []string{}
+		for _, res := range stringList(rule["resources"]) {
+			for _, g := range groups {
+				if g == "" {
+					targets = append(targets, res)
+				} else {
+					targets = append(targets, res+"."+g)
+				}
+			}
+			if len(groups) == 0 {
+				targets = append(targets, res)
+			}
+		}
+		targets = append(targets, stringList(rule["nonResourceURLs"])...)
+
+		s := fmt.Sprintf("%s %s", strings.Join(targets, ","), strings.Join(stringList(rule["verbs"]), ","))
+		if names := stringList(rule["resourceNames"]); len(names) > 0 {
+			s = fmt.Sprintf("%s (%s)", s, strings.Join(names, ","))
+		}
+		formatted = append(formatted, s)
+	}
+	return formatted
+}
+
+func stringList(v interface{}) []string {
+	list, _ := v.([]interface{})
+	strs := []string{}
+	for _, e := range list {
+		if s, ok := e.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+// shortenImages drops the repository from the images if it is the same, e.g. `1.8.0` and `1.8.3`
+func shortenImages(previous, current string) (string, string) {
+	prevRepo, prevTag := splitImage(previous)
+	curRepo, curTag := splitImage(current)
+	if prevRepo == curRepo && prevTag != "" && curTag != "" {
+		return prevTag, curTag
+	}
+	return previous, current
+}
+
+// splitImage splits the image into the repository and the tag or digest
+func splitImage(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i+1:]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
+
+// lineStat returns the number of the added and removed lines
+func lineStat(previous, current string) (added, removed int) {
+	for _, d := range diff.Do(previous, current) {
+		lines := strings.Count(d.Text, "\n")
+		if !strings.HasSuffix(d.Text, "\n") {
+			lines++
+		}
+
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			added += lines
+		case diffmatchpatch.DiffDelete:
+			removed += lines
+		}
+	}
+	return added, removed
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,150 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// deployment returns the manifest of the Deployment with the containers written in JSON
+func deployment(containers string) string {
+	return fmt.Sprintf(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers: %s
+`, containers)
+}
+
+var _ = Describe("summarizeChanges", func() {
+	DescribeTable("describes the changes of the fields",
+		func(previous, current string, expected []string) {
+			Expect(summarizeChanges([]byte(previous), []byte(current))).To(Equal(expected))
+		},
+		Entry("a scalar",
+			"{kind: Deployment, spec: {replicas: 2}}",
+			"{kind: Deployment, spec: {replicas: 3}}",
+			[]string{"spec.replicas 2→3"}),
+		Entry("the fields added and removed",
+			"{kind: Deployment, spec: {paused: true}}",
+			"{kind: Deployment, spec: {replicas: 3, strategy: {type: Recreate}}}",
+			[]string{"spec.paused removed", "spec.replicas added", "spec.strategy added"}),
+		Entry("a key quoted by brackets",
+			"{kind: Deployment, metadata: {labels: {app.kubernetes.io/version: '1.0'}}}",
+			"{kind: Deployment, metadata: {labels: {app.kubernetes.io/version: '1.1'}}}",
+			[]string{"metadata.labels[app.kubernetes.io/version] 1.0→1.1"}),
+		Entry("the tag of a container image",
+			deployment(`[{"name": "coredns", "image": "k8s.gcr.io/coredns:1.8.0"}]`),
+			deployment(`[{"name": "coredns", "image": "k8s.gcr.io/coredns:1.8.3"}]`),
+			[]string{"container coredns image 1.8.0→1.8.3"}),
+		Entry("the repository of a container image",
+			deployment(`[{"name": "coredns", "image": "k8s.gcr.io/coredns:1.8.0"}]`),
+			deployment(`[{"name": "coredns", "image": "registry.k8s.io/coredns:1.8.0"}]`),
+			[]string{"container coredns image k8s.gcr.io/coredns:1.8.0→registry.k8s.io/coredns:1.8.0"}),
+		Entry("the digest of a container image",
+			deployment(`[{"name": "coredns", "image": "coredns@sha256:aaaa"}]`),
+			deployment(`[{"name": "coredns", "image": "coredns@sha256:bbbb"}]`),
+			[]string{"container coredns image sha256:aaaa→sha256:bbbb"}),
+		Entry("the lines of a ConfigMap key",
+			"{kind: ConfigMap, data: {Corefile: \".:53 {\\n    errors\\n    health\\n}\\n\"}}",
+			"{kind: ConfigMap, data: {Corefile: \".:53 {\\n    errors\\n    log\\n    cache 30\\n    reload\\n}\\n\"}}",
+			[]string{"ConfigMap key Corefile modified (+3/-1 lines)"}),
+		Entry("the keys of a ConfigMap added and removed",
+			"{kind: ConfigMap, data: {old.conf: a}}",
+			"{kind: ConfigMap, data: {new.conf: b}}",
+			[]string{"ConfigMap key new.conf added", "ConfigMap key old.conf removed"}),
+		Entry("the multi-line value of another kind",
+			"{kind: Job, spec: {script: \"a\\nb\\n\"}}",
+			"{kind: Job, spec: {script: \"a\\nc\\n\"}}",
+			[]string{"spec.script modified (+1/-1 lines)"}),
+		Entry("a map replaced by a scalar",
+			"{kind: Service, spec: {ports: {http: 80}}}",
+			"{kind: Service, spec: {ports: 80}}",
+			[]string{"spec.ports changed"}),
+		Entry("the rules of a ClusterRole added and removed",
+			"{kind: ClusterRole, rules: [{apiGroups: [''], resources: [configmaps], verbs: [get]}]}",
+			"{kind: ClusterRole, rules: [{apiGroups: [''], resources: [secrets], verbs: [get, list]}, {apiGroups: [apps], resources: [deployments], verbs: [get], resourceNames: [coredns]}]}",
+			[]string{
+				"ClusterRole rule added: secrets get,list",
+				"ClusterRole rule added: deployments.apps get (coredns)",
+				"ClusterRole rule removed: configmaps get",
+			}),
+		Entry("the non-resource URLs of a Role",
+			"{kind: Role, rules: []}",
+			"{kind: Role, rules: [{nonResourceURLs: [/healthz], verbs: [get]}]}",
+			[]string{"Role rule added: /healthz get"}),
+		Entry("nothing without the previous manifest",
+			"",
+			deployment(`[{"name": "coredns", "image": "k8s.gcr.io/coredns:1.8.0"}]`),
+			[]string{}),
+	)
+
+	DescribeTable("describes the changes in the nested lists",
+		func(previous, current string, expected []string) {
+			Expect(summarizeChanges([]byte(previous), []byte(current))).To(Equal(expected))
+		},
+		Entry("a field of an element matched by its name",
+			deployment(`[{"name": "app", "env": [{"name": "A", "value": "1"}, {"name": "B", "value": "2"}]}]`),
+			deployment(`[{"name": "app", "env": [{"name": "B", "value": "2"}, {"name": "A", "value": "3"}]}]`),
+			[]string{"spec.template.spec.containers[app].env[A].value 1→3"}),
+		Entry("the elements added and removed in the order of the lists",
+			deployment(`[{"name": "app", "env": [{"name": "A"}, {"name": "B"}, {"name": "C"}]}]`),
+			deployment(`[{"name": "app", "env": [{"name": "D"}, {"name": "B"}, {"name": "E"}]}]`),
+			[]string{
+				"spec.template.spec.containers[app].env[A] removed",
+				"spec.template.spec.containers[app].env[C] removed",
+				"spec.template.spec.containers[app].env[D] added",
+				"spec.template.spec.containers[app].env[E] added",
+			}),
+		Entry("the changes of several containers",
+			deployment(`[{"name": "app", "image": "app:v1", "ports": [{"name": "http", "containerPort": 80}]}, {"name": "sidecar", "image": "sidecar:v1"}]`),
+			deployment(`[{"name": "app", "image": "app:v2", "ports": [{"name": "http", "containerPort": 8080}]}, {"name": "proxy", "image": "proxy:v1"}]`),
+			[]string{
+				"container app image v1→v2",
+				"spec.template.spec.containers[app].ports[http].containerPort 80→8080",
+				"container sidecar removed",
+				"container proxy added",
+			}),
+		Entry("a list whose elements are not named as a whole",
+			deployment(`[{"name": "app", "args": ["--port", "80"]}]`),
+			deployment(`[{"name": "app", "args": ["--port", "8080"]}]`),
+			[]string{"spec.template.spec.containers[app].args changed"}),
+		Entry("a list with an element without the name as a whole",
+			deployment(`[{"name": "app", "ports": [{"name": "http", "containerPort": 80}, {"containerPort": 9090}]}]`),
+			deployment(`[{"name": "app", "ports": [{"name": "http", "containerPort": 8080}, {"containerPort": 9090}]}]`),
+			[]string{"spec.template.spec.containers[app].ports changed"}),
+		Entry("a list with the duplicated names as a whole",
+			deployment(`[{"name": "app", "env": [{"name": "A", "value": "1"}, {"name": "A", "value": "2"}]}]`),
+			deployment(`[{"name": "app", "env": [{"name": "A", "value": "1"}, {"name": "A", "value": "3"}]}]`),
+			[]string{"spec.template.spec.containers[app].env changed"}),
+		Entry("a list nested in an unnamed list as a whole",
+			`{"kind": "NetworkPolicy", "spec": {"ingress": [{"ports": [{"port": 80}]}]}}`,
+			`{"kind": "NetworkPolicy", "spec": {"ingress": [{"ports": [{"port": 8080}]}]}}`,
+			[]string{"spec.ingress changed"}),
+		Entry("nothing for the lists in the same order",
+			deployment(`[{"name": "app", "env": [{"name": "A"}, {"name": "B"}]}]`),
+			deployment(`[{"name": "app", "env": [{"name": "A"}, {"name": "B"}]}]`),
+			[]string{}),
+	)
+})
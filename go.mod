@@ -3,10 +3,16 @@ module github.com/terakoya76/manifest-capturer
 go 1.14
 
 require (
+	filippo.io/age v1.0.0
+	github.com/ProtonMail/go-crypto v0.0.0-20210428141323-04723f9f07d7
+	github.com/evanphx/json-patch v4.5.0+incompatible
 	github.com/go-git/go-git/v5 v5.2.0
 	github.com/go-logr/logr v0.1.0
 	github.com/onsi/ginkgo v1.15.2
 	github.com/onsi/gomega v1.10.1
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/sergi/go-diff v1.1.0
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
 	k8s.io/api v0.18.2
 	k8s.io/apimachinery v0.18.2
 	k8s.io/client-go v0.18.2
@@ -18,7 +18,10 @@ package main
 
 import (
 	"flag"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -46,10 +49,26 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var auditWebhookAddr string
+	var auditWait time.Duration
+	var auditCertFile, auditKeyFile, auditClientCAFile, auditTokenFile string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&auditWebhookAddr, "audit-webhook-addr", "",
+		"The address the audit webhook endpoint binds to. "+
+			"Setting this enables the attribution of the captured changes to the requesting users.")
+	flag.DurationVar(&auditWait, "audit-wait", 10*time.Second,
+		"How long a capture is delayed for the audit event of the change.")
+	flag.StringVar(&auditCertFile, "audit-webhook-cert-file", "",
+		"The serving certificate of the audit webhook endpoint.")
+	flag.StringVar(&auditKeyFile, "audit-webhook-key-file", "",
+		"The serving key of the audit webhook endpoint.")
+	flag.StringVar(&auditClientCAFile, "audit-webhook-client-ca-file", "",
+		"The CA bundle verifying the client certificate of the audit webhook backend.")
+	flag.StringVar(&auditTokenFile, "audit-webhook-token-file", "",
+		"The file of the bearer token accepted from the audit webhook backend.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -75,60 +94,48 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controller.ClusterRoleController{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ClusterRoleController"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ClusterRoleController")
-		os.Exit(1)
-	}
-	if err = (&controller.ClusterRoleBindingController{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ClusterRoleBindingController"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ClusterRoleBindingController")
-		os.Exit(1)
-	}
-	if err = (&controller.ConfigMapController{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ConfigMapController"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ConfigMapController")
-		os.Exit(1)
+	var audit *controller.AuditReceiver
+	if auditWebhookAddr != "" {
+		audit = &controller.AuditReceiver{
+			Log:          ctrl.Log.WithName("audit"),
+			BindAddress:  auditWebhookAddr,
+			CertFile:     auditCertFile,
+			KeyFile:      auditKeyFile,
+			ClientCAFile: auditClientCAFile,
+			Mapper:       mgr.GetRESTMapper(),
+			Wait:         auditWait,
+		}
+		if auditTokenFile != "" {
+			token, err := ioutil.ReadFile(auditTokenFile)
+			if err != nil {
+				setupLog.Error(err, "unable to read audit webhook token")
+				os.Exit(1)
+			}
+			audit.Token = strings.TrimSpace(string(token))
+		}
+		if err = mgr.Add(audit); err != nil {
+			setupLog.Error(err, "unable to add audit webhook receiver")
+			os.Exit(1)
+		}
 	}
-	if err = (&controller.DeploymentController{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("DeploymentController"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "DeploymentController")
-		os.Exit(1)
-	}
-	if err = (&controller.SecretController{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("SecretController"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "SecretController")
-		os.Exit(1)
-	}
-	if err = (&controller.ServiceController{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ServiceController"),
-		Scheme: mgr.GetScheme(),
+
+	if err = (&controller.CapturerController{
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("CapturerController"),
+		Scheme:    mgr.GetScheme(),
+		APIReader: mgr.GetAPIReader(),
+		Audit:     audit,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ServiceController")
+		setupLog.Error(err, "unable to create controller", "controller", "CapturerController")
 		os.Exit(1)
 	}
-	if err = (&controller.ServiceAccountCountroller{
+
+	if err = (&controller.RestoreController{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ServiceAccountCountroller"),
+		Log:    ctrl.Log.WithName("controllers").WithName("RestoreController"),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ServiceAccountCountroller")
+		setupLog.Error(err, "unable to create controller", "controller", "RestoreController")
 		os.Exit(1)
 	}
 